@@ -7,11 +7,21 @@ package clio
 
 import (
     "fmt"
+    "io"
+    "bufio"
     "os"
     "strings"
     "strconv"
     "unicode"
+    "unicode/utf8"
     "sort"
+    "path/filepath"
+    "net"
+    "net/url"
+    "encoding/json"
+    "time"
+    "math"
+    "reflect"
 )
 
 
@@ -19,10 +29,131 @@ import (
 const Version = "2.1.0"
 
 
-// Print a message to stderr and exit with an error code.
-func exit(msg string) {
-    fmt.Fprintf(os.Stderr, "Error: %v.\n", msg)
-    os.Exit(1)
+// Print a message to w followed by an optional footer line and exit with an
+// error code.
+func exitWithFooter(w io.Writer, msg, footer string) {
+    fmt.Fprintf(w, "Error: %v.\n", msg)
+    if footer != "" {
+        fmt.Fprintln(w, footer)
+    }
+    osExit(1)
+}
+
+
+// Terminates the process. This is a variable so it can be replaced by
+// Capture().
+var osExit = os.Exit
+
+
+// Source of interactive input and a test for whether it is a terminal. These
+// are variables so they can be replaced in tests. A single buffered reader is
+// shared by every read so input buffered past the end of one line is kept
+// for the next.
+var stdin = bufio.NewReader(os.Stdin)
+var isInteractive = func() bool {
+    info, err := os.Stdin.Stat()
+    return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
+
+
+// Returns a channel which receives once a read from stdin has timed out. This
+// is a variable so it can be replaced in tests.
+var stdinTimer = time.After
+
+
+// The result of reading a line from stdin.
+type lineResult struct {
+    line string
+    err error
+}
+
+
+// A read from stdin abandoned by a timeout, if any. Its goroutine remains
+// blocked until a line arrives or stdin is closed; the next call to
+// readLine() waits for its result rather than starting a second read, so
+// at most one goroutine is ever leaked and no input is lost.
+var pendingLine chan lineResult
+
+
+// Read a single line of input from stdin, minus its line ending. If timeout
+// is positive, give up and return an error if no line arrives in time.
+func readLine(timeout time.Duration) (string, error) {
+    ch := pendingLine
+    pendingLine = nil
+    if ch == nil {
+        ch = make(chan lineResult, 1)
+        go func() {
+            line, err := stdin.ReadString('\n')
+            if err == io.EOF && line != "" {
+                err = nil
+            }
+            ch <- lineResult{strings.TrimRight(line, "\r\n"), err}
+        }()
+    }
+    if timeout <= 0 {
+        r := <-ch
+        return r.line, r.err
+    }
+    select {
+    case r := <-ch:
+        return r.line, r.err
+    case <-stdinTimer(timeout):
+        pendingLine = ch
+        return "", fmt.Errorf("timed out after %v waiting for input", timeout)
+    }
+}
+
+
+// Formats for error messages printed on exit.
+const (
+    ErrorFormatText = iota
+    ErrorFormatJSON
+)
+
+
+// A ParseError describes a failure to parse the command line.
+type ParseError struct {
+    Message string
+
+    // The option involved in the failure, as it appeared on the command
+    // line, e.g. --name. Empty if the failure involved no single option.
+    Option string
+}
+
+
+// Error returns the error's message.
+func (err *ParseError) Error() string {
+    return err.Message
+}
+
+
+// Abort parsing with an error message. The resulting panic is recovered by
+// catch() in the parser's public entry points.
+func fail(msg string) {
+    panic(&ParseError{Message: msg})
+}
+
+
+// Abort parsing with an error message involving the specified option.
+func failOption(option, msg string) {
+    panic(&ParseError{Message: msg, Option: option})
+}
+
+
+// Run a function, recovering a parse failure and returning it as an error.
+// Any other panic is propagated.
+func catch(fn func()) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            parseErr, ok := r.(*ParseError)
+            if !ok {
+                panic(r)
+            }
+            err = parseErr
+        }
+    }()
+    fn()
+    return nil
 }
 
 
@@ -39,15 +170,40 @@ const (
     strOpt
     intOpt
     floatOpt
+    ipOpt
+    urlOpt
+    intMapOpt
+    indexedOpt
+    byteDeltaOpt
+    mapOpt
+    pairsOpt
 )
 
 
-// Union combining all four valid types of option value.
+// Union combining all valid types of option value.
 type optionValue struct {
     boolVal bool
     strVal string
     intVal int
     floatVal float64
+    ipVal net.IP
+    urlVal *url.URL
+    intMap map[string]int
+    key string
+    bytesVal int64
+
+    // A secret string option stores its parsed values here rather than in
+    // strVal so they can be zeroed by GetSecretAndClear().
+    secretVal []byte
+}
+
+
+// Returns a string value, whether stored as a string or as a secret.
+func (value optionValue) str() string {
+    if value.secretVal != nil {
+        return string(value.secretVal)
+    }
+    return value.strVal
 }
 
 
@@ -56,7 +212,91 @@ type option struct {
     optType int
     found bool
     greedy bool
+    isList bool
     values []optionValue
+
+    // The option's aliases in registration order.
+    names []string
+
+    // The registration-time default value. Nil for list options.
+    def *optionValue
+
+    // If true, a greedy list consumes a '--' terminator and all following
+    // arguments instead of stopping at it.
+    consumeTerminator bool
+
+    // If non-empty, the option may only be used with these commands.
+    scope []string
+
+    // If non-empty, the URL schemes accepted by a URL option.
+    schemes []string
+
+    // Optional predicate overriding the default test for whether the next
+    // argument counts as one of the option's values.
+    isValue func(string) bool
+
+    // If true, the option captures every argument following it.
+    capture bool
+
+    // If true, parsed values already present in the list are skipped.
+    unique bool
+
+    // If non-empty, the confirmation prompt shown when a confirmation flag
+    // is absent.
+    prompt string
+
+    // If non-empty, list values are split on this delimiter.
+    delimiter string
+
+    // The separator between the key and value of a pairs option.
+    pairSep string
+
+    // If non-empty, a greedy list stops at this token and discards it.
+    sentinel string
+
+    // If true, each occurrence of the flag flips its current value.
+    toggle bool
+
+    // If true, the flag can be set to false using the long form --no-name.
+    negatable bool
+
+    // The source of the option's current value.
+    source Source
+
+    // If non-empty, the values accepted by an enum option.
+    choices []string
+
+    // If true, enum values are matched ignoring case.
+    foldChoices bool
+
+    // Optional option whose value is used in place of this option's if this
+    // option is not found.
+    fallback *option
+
+    // If true, the option's values are masked in printed output.
+    secret bool
+
+    // If true, parsing fails if the option receives no value from the
+    // command line or another non-default source.
+    required bool
+
+    // Optional description and argument placeholder shown in generated help
+    // text.
+    desc string
+    metavar string
+
+    // Optional per-value validator for list options.
+    validator func(optionValue) error
+
+    // Optional function computing a string option's default on retrieval.
+    defaultFrom func() string
+
+    // The parser on which the option is registered.
+    parser *ArgParser
+
+    // If non-empty, the environment variable supplying a value for the
+    // option.
+    envVar string
 }
 
 
@@ -72,9 +312,30 @@ func (opt *option) setFlag(value bool) {
 }
 
 
+// Record the presence of a flag on the command line. A toggle flips its
+// current value; any other flag stores the boolean true.
+func (opt *option) setPresent() {
+    if opt.toggle {
+        opt.setFlag(!opt.getFlag())
+        return
+    }
+    opt.setFlag(true)
+}
+
+
 // Append a value to a string option's internal list.
 func (opt *option) setStr(value string) {
-    opt.values = append(opt.values, optionValue{strVal: value})
+    opt.values = append(opt.values, opt.protect(optionValue{strVal: value}))
+}
+
+
+// Moves a secret string option's value into wipeable storage.
+func (opt *option) protect(value optionValue) optionValue {
+    if opt.secret && opt.optType == strOpt {
+        value.secretVal = []byte(value.strVal)
+        value.strVal = ""
+    }
+    return value
 }
 
 
@@ -90,28 +351,340 @@ func (opt *option) setFloat(value float64) {
 }
 
 
-// Try setting an option by parsing the value of a string argument. Exit
-// with an error message on failure.
-func (opt *option) trySet(arg string) {
+// Append a value to an IP address option's internal list.
+func (opt *option) setIP(value net.IP) {
+    opt.values = append(opt.values, optionValue{ipVal: value})
+}
+
+
+// Append a value to a URL option's internal list.
+func (opt *option) setURL(value *url.URL) {
+    opt.values = append(opt.values, optionValue{urlVal: value})
+}
+
+
+// Abort parsing with an error message involving the option.
+func (opt *option) fail(msg string) {
+    failOption(optionLabel(opt.names[0]), msg)
+}
+
+
+// Returns an argument as it should appear in an error message, masking the
+// value of a secret option.
+func (opt *option) displayArg(arg string) string {
+    if opt.secret {
+        return "****"
+    }
+    return arg
+}
+
+
+// Exit with an error from one of the parse helpers, masking the argument
+// quoted in its message if the option is secret.
+func (opt *option) failParse(err error, arg string) {
+    msg := err.Error()
+    if opt.secret {
+        msg = strings.Replace(msg, "'"+arg+"'", "'****'", 1)
+    }
+    opt.fail(msg)
+}
+
+
+// Parse a string argument as a value of the option's type. Exit with an
+// error message on failure.
+func (opt *option) parseValue(arg string) optionValue {
     switch opt.optType {
 
-    case strOpt:
-        opt.setStr(arg)
+    case flagOpt:
+        boolVal, err := parseBool(arg)
+        if err != nil {
+            opt.failParse(err, arg)
+        }
+        return optionValue{boolVal: boolVal}
 
     case intOpt:
-        intVal, err := strconv.ParseInt(arg, 0, 0)
+        intVal, err := parseInt(arg, opt.decimalOnly())
         if err != nil {
-            exit(fmt.Sprintf("cannot parse '%v' as an integer", arg))
+            opt.failParse(err, arg)
         }
-        opt.setInt(int(intVal))
+        return optionValue{intVal: intVal}
 
     case floatOpt:
         floatVal, err := strconv.ParseFloat(arg, 64)
         if err != nil {
-            exit(fmt.Sprintf("cannot parse '%v' as a float", arg))
+            opt.fail(fmt.Sprintf("cannot parse '%v' as a float", opt.displayArg(arg)))
+        }
+        return optionValue{floatVal: floatVal}
+
+    case ipOpt:
+        ipVal := net.ParseIP(arg)
+        if ipVal == nil {
+            opt.fail(fmt.Sprintf("cannot parse '%v' as an IP address", opt.displayArg(arg)))
+        }
+        return optionValue{ipVal: ipVal}
+
+    case urlOpt:
+        urlVal, err := url.Parse(arg)
+        if err != nil {
+            opt.fail(fmt.Sprintf("cannot parse '%v' as a URL", opt.displayArg(arg)))
+        }
+        if urlVal.Scheme == "" {
+            opt.fail(fmt.Sprintf("the URL '%v' is missing a scheme", opt.displayArg(arg)))
+        }
+        if urlVal.Host == "" && urlVal.Scheme != "file" {
+            opt.fail(fmt.Sprintf("the URL '%v' is missing a host", opt.displayArg(arg)))
+        }
+        if len(opt.schemes) > 0 && !containsFold(opt.schemes, urlVal.Scheme) {
+            opt.fail(fmt.Sprintf(
+                "the URL scheme '%v' is not allowed for %v (choose from %v)",
+                urlVal.Scheme,
+                optionLabel(opt.names[0]),
+                strings.Join(opt.schemes, ", "),
+            ))
+        }
+        return optionValue{urlVal: urlVal}
+
+    case intMapOpt:
+        intMap := make(map[string]int)
+        for _, pair := range strings.Split(arg, ",") {
+            split := strings.SplitN(pair, "=", 2)
+            if len(split) != 2 || split[0] == "" {
+                opt.fail(fmt.Sprintf("cannot parse '%v' as a key=value pair", opt.displayArg(pair)))
+            }
+            intVal, err := parseInt(split[1], opt.decimalOnly())
+            if err != nil {
+                opt.failParse(fmt.Errorf("%v for the key '%v'", err, opt.displayArg(split[0])), split[1])
+            }
+            intMap[split[0]] = intVal
+        }
+        return optionValue{intMap: intMap}
+
+    case mapOpt:
+        split := strings.SplitN(arg, "=", 2)
+        if len(split) != 2 || split[0] == "" {
+            opt.fail(fmt.Sprintf("cannot parse '%v' as a key=value pair", opt.displayArg(arg)))
+        }
+        return optionValue{key: split[0], strVal: split[1]}
+
+    case pairsOpt:
+        split := strings.SplitN(arg, opt.pairSep, 2)
+        if len(split) != 2 || split[0] == "" {
+            opt.fail(fmt.Sprintf("cannot parse '%v' as a key%vvalue pair", opt.displayArg(arg), opt.pairSep))
+        }
+        return optionValue{key: split[0], strVal: split[1]}
+
+    case byteDeltaOpt:
+        bytesVal, err := parseByteDelta(arg)
+        if err != nil {
+            opt.failParse(err, arg)
+        }
+        return optionValue{bytesVal: bytesVal}
+
+    case indexedOpt:
+        opt.fail(fmt.Sprintf(
+            "the %v option requires an index and a field, e.g. %v.0.name",
+            optionLabel(opt.names[0]),
+            optionLabel(opt.names[0]),
+        ))
+    }
+
+    if opt.foldChoices {
+        for _, choice := range opt.choices {
+            if strings.EqualFold(choice, arg) {
+                arg = choice
+                break
+            }
+        }
+    }
+    if len(opt.choices) > 0 && !contains(opt.choices, arg) {
+        opt.fail(fmt.Sprintf(
+            "'%v' is not a valid value for %v (choose from %v)",
+            opt.displayArg(arg),
+            optionLabel(opt.names[0]),
+            strings.Join(opt.choices, ", "),
+        ))
+    }
+    return optionValue{strVal: arg}
+}
+
+
+// Try setting an option by parsing the value of a string argument. Exit
+// with an error message on failure. A unique list option silently skips
+// values already present in its list.
+func (opt *option) trySet(arg string) {
+    if opt.delimiter != "" {
+        for _, element := range splitEscaped(arg, opt.delimiter) {
+            opt.setOne(element)
+        }
+        return
+    }
+    opt.setOne(arg)
+}
+
+
+// Split a string on a delimiter. A delimiter preceded by a backslash is
+// treated as part of an element rather than splitting it, and a doubled
+// backslash is treated as a single literal backslash. Any other backslash is
+// left as is.
+func splitEscaped(str, delimiter string) []string {
+    elements := make([]string, 0)
+    var current strings.Builder
+    for i := 0; i < len(str); {
+        switch {
+        case strings.HasPrefix(str[i:], "\\" + delimiter):
+            current.WriteString(delimiter)
+            i += 1 + len(delimiter)
+        case strings.HasPrefix(str[i:], "\\\\"):
+            current.WriteString("\\")
+            i += 2
+        case strings.HasPrefix(str[i:], delimiter):
+            elements = append(elements, current.String())
+            current.Reset()
+            i += len(delimiter)
+        default:
+            current.WriteByte(str[i])
+            i += 1
+        }
+    }
+    return append(elements, current.String())
+}
+
+
+// Parses a single value and appends it to the option's internal list.
+func (opt *option) setOne(arg string) {
+    value := opt.parseValue(arg)
+    if opt.validator != nil {
+        if err := opt.validator(value); err != nil {
+            opt.fail(fmt.Sprintf(
+                "'%v' is not a valid value for %v: %v",
+                opt.displayArg(arg),
+                optionLabel(opt.names[0]),
+                err,
+            ))
+        }
+    }
+    value = opt.protect(value)
+    if opt.unique && opt.hasValue(value) {
+        return
+    }
+    opt.values = append(opt.values, value)
+}
+
+
+// Returns true if the option's internal list contains a value equal to the
+// specified value.
+func (opt *option) hasValue(value optionValue) bool {
+    for _, existing := range opt.values {
+        if opt.equalValues(existing, value) {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Compares two values according to the option's type.
+func (opt *option) equalValues(a, b optionValue) bool {
+    switch opt.optType {
+    case flagOpt:
+        return a.boolVal == b.boolVal
+    case intOpt:
+        return a.intVal == b.intVal
+    case floatOpt:
+        return a.floatVal == b.floatVal
+    case ipOpt:
+        return a.ipVal.Equal(b.ipVal)
+    case urlOpt, intMapOpt, indexedOpt, mapOpt, pairsOpt:
+        return opt.formatValue(a) == opt.formatValue(b)
+    case byteDeltaOpt:
+        return a.bytesVal == b.bytesVal
+    }
+    return a.str() == b.str()
+}
+
+
+// Parse an integer. By default the base is implied by the string's prefix,
+// e.g. 0x10 is hexadecimal and 010 octal; if decimal is true, the string is
+// always parsed as base 10.
+func parseInt(str string, decimal bool) (int, error) {
+    if decimal {
+        intVal, err := strconv.ParseInt(str, 10, 0)
+        if err != nil {
+            return 0, fmt.Errorf("cannot parse '%v' as an integer (expected a decimal integer)", str)
         }
-        opt.setFloat(floatVal)
+        return int(intVal), nil
+    }
+    intVal, err := strconv.ParseInt(str, 0, 0)
+    if err != nil {
+        return 0, fmt.Errorf("cannot parse '%v' as an integer", str)
+    }
+    return int(intVal), nil
+}
+
+
+// Multipliers for the size suffixes accepted by parseByteDelta. Decimal
+// suffixes are powers of 1000, binary suffixes powers of 1024.
+var byteSuffixes = map[string]int64{
+    "b": 1,
+    "kb": 1000,
+    "mb": 1000 * 1000,
+    "gb": 1000 * 1000 * 1000,
+    "tb": 1000 * 1000 * 1000 * 1000,
+    "kib": 1 << 10,
+    "mib": 1 << 20,
+    "gib": 1 << 30,
+    "tib": 1 << 40,
+}
+
+
+// Parse a signed byte count with a required size suffix, e.g. +1GB or
+// -500MiB. Suffixes are matched ignoring case.
+func parseByteDelta(str string) (int64, error) {
+    i := 0
+    if strings.HasPrefix(str, "+") || strings.HasPrefix(str, "-") {
+        i = 1
+    }
+    for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+        i += 1
+    }
+    number, suffix := str[:i], strings.ToLower(str[i:])
+    if suffix == "" {
+        return 0, fmt.Errorf("the byte size '%v' is missing a suffix (e.g. MB)", str)
+    }
+    multiplier, ok := byteSuffixes[suffix]
+    if !ok {
+        return 0, fmt.Errorf("the byte size '%v' has an invalid suffix", str)
+    }
+    count, err := strconv.ParseInt(number, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("cannot parse '%v' as a byte size", str)
     }
+    if count > math.MaxInt64 / multiplier || count < math.MinInt64 / multiplier {
+        return 0, fmt.Errorf("the byte size '%v' is out of range", str)
+    }
+    return count * multiplier, nil
+}
+
+
+// Tokens accepted by parseBool, in the order listed in error messages.
+var boolTokens = []string{"true", "false", "yes", "no", "on", "off", "y", "n", "1", "0"}
+
+
+// Parse a boolean value. In addition to 'true' and 'false' we accept the
+// tokens users commonly type in config files and environment variables:
+// 'yes', 'no', 'on', 'off', 'y', 'n', '1', and '0'. Case is ignored.
+func parseBool(str string) (bool, error) {
+    switch strings.ToLower(str) {
+    case "true", "yes", "on", "y", "1":
+        return true, nil
+    case "false", "no", "off", "n", "0":
+        return false, nil
+    }
+    return false, fmt.Errorf(
+        "cannot parse '%v' as a boolean (expected one of %v)",
+        str,
+        strings.Join(boolTokens, ", "),
+    )
 }
 
 
@@ -121,6 +694,8 @@ func newFlag(value bool) *option {
         optType: flagOpt,
     }
     opt.setFlag(value)
+    def := opt.values[0]
+    opt.def = &def
     return opt
 }
 
@@ -131,6 +706,8 @@ func newStr(value string) *option {
         optType: strOpt,
     }
     opt.setStr(value)
+    def := opt.values[0]
+    opt.def = &def
     return opt
 }
 
@@ -141,6 +718,8 @@ func newInt(value int) *option {
         optType: intOpt,
     }
     opt.setInt(value)
+    def := opt.values[0]
+    opt.def = &def
     return opt
 }
 
@@ -151,6 +730,44 @@ func newFloat(value float64) *option {
         optType: floatOpt,
     }
     opt.setFloat(value)
+    def := opt.values[0]
+    opt.def = &def
+    return opt
+}
+
+
+// Initialize an IP address option with a default value.
+func newIP(value net.IP) *option {
+    opt := &option{
+        optType: ipOpt,
+    }
+    opt.setIP(value)
+    def := opt.values[0]
+    opt.def = &def
+    return opt
+}
+
+
+// Initialize a URL option with a default value.
+func newURL(value *url.URL) *option {
+    opt := &option{
+        optType: urlOpt,
+    }
+    opt.setURL(value)
+    def := opt.values[0]
+    opt.def = &def
+    return opt
+}
+
+
+// Initialize a byte delta option with a default value.
+func newByteDelta(value int64) *option {
+    opt := &option{
+        optType: byteDeltaOpt,
+    }
+    opt.values = append(opt.values, optionValue{bytesVal: value})
+    def := opt.values[0]
+    opt.def = &def
     return opt
 }
 
@@ -159,6 +776,7 @@ func newFloat(value float64) *option {
 func newFlagList() *option {
     opt := &option{
         optType: flagOpt,
+        isList: true,
     }
     return opt
 }
@@ -168,6 +786,7 @@ func newFlagList() *option {
 func newStrList(greedy bool) *option {
     opt := &option{
         optType: strOpt,
+        isList: true,
     }
     opt.greedy = greedy
     return opt
@@ -178,6 +797,7 @@ func newStrList(greedy bool) *option {
 func newIntList(greedy bool) *option {
     opt := &option{
         optType: intOpt,
+        isList: true,
     }
     opt.greedy = greedy
     return opt
@@ -188,6 +808,18 @@ func newIntList(greedy bool) *option {
 func newFloatList(greedy bool) *option {
     opt := &option{
         optType: floatOpt,
+        isList: true,
+    }
+    opt.greedy = greedy
+    return opt
+}
+
+
+// Initialize an IP address list option.
+func newIPList(greedy bool) *option {
+    opt := &option{
+        optType: ipOpt,
+        isList: true,
     }
     opt.greedy = greedy
     return opt
@@ -200,9 +832,22 @@ func (opt *option) getFlag() bool {
 }
 
 
+// Returns true if the option's value was supplied by the command line or by
+// another source, rather than being its default.
+func (opt *option) isSet() bool {
+    return opt.found || opt.source != SourceDefault
+}
+
+
 // Returns the value of a string option.
 func (opt *option) getStr() string {
-    return opt.values[len(opt.values) - 1].strVal
+    if !opt.isSet() && opt.fallback != nil {
+        return opt.fallback.getStr()
+    }
+    if !opt.isSet() && opt.defaultFrom != nil {
+        return opt.defaultFrom()
+    }
+    return opt.values[len(opt.values) - 1].str()
 }
 
 
@@ -218,6 +863,24 @@ func (opt *option) getFloat() float64 {
 }
 
 
+// Returns the value of an IP address option.
+func (opt *option) getIP() net.IP {
+    return opt.values[len(opt.values) - 1].ipVal
+}
+
+
+// Returns the value of a byte delta option.
+func (opt *option) getByteDelta() int64 {
+    return opt.values[len(opt.values) - 1].bytesVal
+}
+
+
+// Returns the value of a URL option.
+func (opt *option) getURL() *url.URL {
+    return opt.values[len(opt.values) - 1].urlVal
+}
+
+
 // Returns a list option's values as a slice of booleans.
 func (opt *option) getFlagList() []bool {
     values := make([]bool, 0, len(opt.values))
@@ -232,7 +895,7 @@ func (opt *option) getFlagList() []bool {
 func (opt *option) getStrList() []string {
     values := make([]string, 0, len(opt.values))
     for _, optVal := range opt.values {
-        values = append(values, optVal.strVal)
+        values = append(values, optVal.str())
     }
     return values
 }
@@ -258,57 +921,301 @@ func (opt *option) getFloatList() []float64 {
 }
 
 
-// -------------------------------------------------------------------------
-// ArgStream
-// -------------------------------------------------------------------------
+// Initialize an integer map option.
+func newIntMap() *option {
+    opt := &option{
+        optType: intMapOpt,
+        isList: true,
+    }
+    return opt
+}
 
 
-// Makes a slice of string arguments available as a stream.
-type argStream struct {
-    args []string
-    index int
-    length int
+// Returns an integer map option's values merged into a single map. Where a
+// key appears more than once the last value wins.
+func (opt *option) getIntMap() map[string]int {
+    merged := make(map[string]int)
+    for _, optVal := range opt.values {
+        for key, value := range optVal.intMap {
+            merged[key] = value
+        }
+    }
+    return merged
 }
 
 
-// Initialize a new argStream instance.
-func newArgStream(args []string) *argStream {
-    return &argStream{
-        args: args,
-        index: 0,
-        length: len(args),
+// Initialize a string map option.
+func newMap() *option {
+    opt := &option{
+        optType: mapOpt,
+        isList: true,
     }
+    return opt
 }
 
 
-// Returns the next argument from the stream.
-func (stream *argStream) next() string {
-    stream.index += 1
-    return stream.args[stream.index - 1]
+// Initialize a pairs option with the specified key-value separator.
+func newPairs(sep string) *option {
+    opt := &option{
+        optType: pairsOpt,
+        isList: true,
+        pairSep: sep,
+    }
+    return opt
 }
 
 
-// Returns the next argument from the stream without consuming it.
-func (stream *argStream) peek() string {
-    return stream.args[stream.index]
+// Returns a pairs option's values as key-value pairs in order.
+func (opt *option) getPairs() [][2]string {
+    pairs := make([][2]string, 0, len(opt.values))
+    for _, optVal := range opt.values {
+        pairs = append(pairs, [2]string{optVal.key, optVal.strVal})
+    }
+    return pairs
 }
 
 
-// Returns true if the stream contains at least one more element.
-func (stream *argStream) hasNext() bool {
-    return stream.index < stream.length
+// Returns a string map option's values as a map, along with its keys in
+// order of first appearance. Where a key appears more than once the last
+// value wins.
+func (opt *option) getMapOrdered() ([]string, map[string]string) {
+    keys := make([]string, 0)
+    merged := make(map[string]string)
+    for _, optVal := range opt.values {
+        if _, ok := merged[optVal.key]; !ok {
+            keys = append(keys, optVal.key)
+        }
+        merged[optVal.key] = optVal.strVal
+    }
+    return keys, merged
 }
 
 
-// Returns true if the stream contains at least one more element and that
-// element has the form of an option value.
-func (stream *argStream) hasNextValue() bool {
-    if stream.hasNext() {
-        next := stream.peek()
-        if strings.HasPrefix(next, "-") {
-            if next == "-" || unicode.IsDigit([]rune(next)[1]) {
-                return true
-            } else {
+// Initialize an indexed option.
+func newIndexed() *option {
+    opt := &option{
+        optType: indexedOpt,
+        isList: true,
+    }
+    return opt
+}
+
+
+// Returns an indexed option's values grouped by index. Indices with no
+// values are represented by empty maps.
+func (opt *option) getIndexed() []map[string]string {
+    groups := make([]map[string]string, 0)
+    for _, optVal := range opt.values {
+        split := strings.SplitN(optVal.key, ".", 2)
+        index, _ := strconv.Atoi(split[0])
+        for len(groups) <= index {
+            groups = append(groups, make(map[string]string))
+        }
+        groups[index][split[1]] = optVal.strVal
+    }
+    return groups
+}
+
+
+// Returns a list option's values as a slice of IP addresses.
+func (opt *option) getIPList() []net.IP {
+    values := make([]net.IP, 0, len(opt.values))
+    for _, optVal := range opt.values {
+        values = append(values, optVal.ipVal)
+    }
+    return values
+}
+
+
+// Returns true if the stream's next argument should be treated as a value
+// for the option.
+func (opt *option) hasNextValue(stream *ArgStream) bool {
+    if opt.isValue != nil {
+        return stream.HasNext() && opt.isValue(stream.Peek())
+    }
+    return stream.HasNextValue()
+}
+
+
+// Returns the name of the option's type.
+func (opt *option) typeName() string {
+    switch opt.optType {
+    case flagOpt:
+        return "flag"
+    case strOpt:
+        return "str"
+    case intOpt:
+        return "int"
+    case floatOpt:
+        return "float"
+    case ipOpt:
+        return "ip"
+    case urlOpt:
+        return "url"
+    case intMapOpt:
+        return "intmap"
+    case byteDeltaOpt:
+        return "bytedelta"
+    case indexedOpt:
+        return "indexed"
+    case mapOpt:
+        return "map"
+    case pairsOpt:
+        return "pairs"
+    }
+    return ""
+}
+
+
+// Returns true if the option collects its values into a map. Map options are
+// stored as lists internally but merge their repeated values, so they aren't
+// presented as lists.
+func (opt *option) isMap() bool {
+    switch opt.optType {
+    case intMapOpt, mapOpt, pairsOpt, indexedOpt:
+        return true
+    }
+    return false
+}
+
+
+// Returns the option's type as shown in generated documentation, e.g. "int
+// list (greedy)".
+func (opt *option) displayType() string {
+    if !opt.isList || opt.isMap() {
+        return opt.typeName()
+    }
+    if opt.greedy {
+        return opt.typeName() + " list (greedy)"
+    }
+    return opt.typeName() + " list"
+}
+
+
+// Formats a single value according to the option's type.
+func (opt *option) formatValue(value optionValue) string {
+    switch opt.optType {
+    case flagOpt:
+        return fmt.Sprintf("%v", value.boolVal)
+    case strOpt:
+        return value.str()
+    case intOpt:
+        return fmt.Sprintf("%v", value.intVal)
+    case floatOpt:
+        return fmt.Sprintf("%v", value.floatVal)
+    case ipOpt:
+        return value.ipVal.String()
+    case urlOpt:
+        if value.urlVal == nil {
+            return ""
+        }
+        return value.urlVal.String()
+    case intMapOpt:
+        keys := make([]string, 0, len(value.intMap))
+        for key := range value.intMap {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        pairs := make([]string, 0, len(keys))
+        for _, key := range keys {
+            pairs = append(pairs, fmt.Sprintf("%v=%v", key, value.intMap[key]))
+        }
+        return strings.Join(pairs, ",")
+    case indexedOpt, mapOpt:
+        return value.key + "=" + value.strVal
+    case pairsOpt:
+        return value.key + opt.pairSep + value.strVal
+    case byteDeltaOpt:
+        return fmt.Sprintf("%+dB", value.bytesVal)
+    }
+    return ""
+}
+
+
+// Returns true if the option's integer values must be decimal.
+func (opt *option) decimalOnly() bool {
+    return opt.parser != nil && opt.parser.decimalOnly()
+}
+
+
+// Formats a single value for display, masking the value of a secret option.
+func (opt *option) displayValue(value optionValue) string {
+    if opt.secret {
+        return "****"
+    }
+    return opt.formatValue(value)
+}
+
+
+// -------------------------------------------------------------------------
+// ArgStream
+// -------------------------------------------------------------------------
+
+
+// An ArgStream makes a slice of string arguments available as a stream. The
+// parser passes its stream to unknown-option handlers so they can consume
+// any values belonging to the unknown option.
+type ArgStream struct {
+    args []string
+    index int
+    length int
+
+    // The roles of the arguments examined so far, indexed like args. The
+    // zero value's role is RoleUnparsed.
+    roles []ArgRole
+
+    // If true, the arguments are parsed without validating them or running
+    // callbacks, for the --debug-args flag.
+    dryRun bool
+
+    // The parser currently consuming the stream, responsible for any
+    // failure.
+    parser *ArgParser
+
+    // The indexes of arguments holding the values of secret options, masked
+    // in the --debug-args output.
+    secrets map[int]bool
+}
+
+
+// Initialize a new ArgStream instance.
+func newArgStream(args []string) *ArgStream {
+    return &ArgStream{
+        args: args,
+        index: 0,
+        length: len(args),
+    }
+}
+
+
+// Next returns the next argument from the stream.
+func (stream *ArgStream) Next() string {
+    stream.index += 1
+    return stream.args[stream.index - 1]
+}
+
+
+// Peek returns the next argument from the stream without consuming it.
+func (stream *ArgStream) Peek() string {
+    return stream.args[stream.index]
+}
+
+
+// HasNext returns true if the stream contains at least one more element.
+func (stream *ArgStream) HasNext() bool {
+    return stream.index < stream.length
+}
+
+
+// HasNextValue returns true if the stream contains at least one more element
+// and that element has the form of an option value.
+func (stream *ArgStream) HasNextValue() bool {
+    if stream.HasNext() {
+        next := stream.Peek()
+        if strings.HasPrefix(next, "-") {
+            if next == "-" || unicode.IsDigit([]rune(next)[1]) {
+                return true
+            } else {
                 return false
             }
         } else {
@@ -319,6 +1226,88 @@ func (stream *argStream) hasNextValue() bool {
 }
 
 
+// Record the role of the argument at the specified index. The option is the
+// name of the option involved, if any.
+func (stream *ArgStream) mark(index int, role Role, option string) {
+    for len(stream.roles) <= index {
+        stream.roles = append(stream.roles, ArgRole{})
+    }
+    stream.roles[index] = ArgRole{Role: role, Option: option}
+}
+
+
+// Record the arguments from the specified index up to the current position
+// as values of the named option.
+func (stream *ArgStream) markValues(start int, option string) {
+    for i := start; i < stream.index && i < stream.length; i++ {
+        stream.mark(i, RoleValue, option)
+    }
+}
+
+
+// Record the arguments from the specified index up to the current position
+// as holding secret values.
+func (stream *ArgStream) markSecret(start int) {
+    if stream.secrets == nil {
+        stream.secrets = make(map[int]bool)
+    }
+    for i := start; i < stream.index && i < stream.length; i++ {
+        stream.secrets[i] = true
+    }
+}
+
+
+// A Role classifies the part an argument played in parsing. See ArgRoles().
+type Role int
+
+
+// Argument roles.
+const (
+    RoleUnparsed Role = iota
+    RoleOption
+    RoleValue
+    RolePositional
+    RoleCommand
+    RoleTerminator
+)
+
+
+// String returns the role's name.
+func (role Role) String() string {
+    switch role {
+    case RoleOption:
+        return "option"
+    case RoleValue:
+        return "value"
+    case RolePositional:
+        return "positional"
+    case RoleCommand:
+        return "command"
+    case RoleTerminator:
+        return "terminator"
+    }
+    return "unparsed"
+}
+
+
+// An ArgRole describes the part a single argument played in parsing.
+type ArgRole struct {
+
+    // The argument as it appeared in the input.
+    Arg string
+
+    // The argument's role.
+    Role Role
+
+    // For an option, the option name as written, without dashes or any
+    // =value suffix, e.g. "out" for --out=foo or "abc" for the cluster -abc.
+    // For a value, the primary name of the option receiving it, or the name
+    // as written for an option handled by an unknown-option handler. Empty
+    // for other roles.
+    Option string
+}
+
+
 // -------------------------------------------------------------------------
 // ArgParser
 // -------------------------------------------------------------------------
@@ -328,6 +1317,62 @@ func (stream *argStream) hasNextValue() bool {
 type cmdCallback func(*ArgParser)
 
 
+// Middleware wrapping a command callback.
+type middleware func(func(*ArgParser)) func(*ArgParser)
+
+
+// A conditional requirement between two options. If unless is false,
+// target is required if cond was found; if unless is true, target is
+// required if cond was not found.
+type dependency struct {
+    cond string
+    target string
+    unless bool
+}
+
+
+// A Requirement is a condition on the parsed command line for use with
+// RequireOneOf(). Requirements are created using OptionPresent() and
+// MinArgs().
+type Requirement struct {
+    desc string
+    holds func(*ArgParser) bool
+
+    // The name of the option the requirement involves, if any.
+    option string
+}
+
+
+// OptionPresent returns a Requirement which holds if the named option has
+// a value from the command line or from another source, e.g. an environment
+// variable.
+func OptionPresent(name string) Requirement {
+    return Requirement{
+        desc: optionLabel(name),
+        holds: func(parser *ArgParser) bool {
+            return parser.lookupOption(name).isSet()
+        },
+        option: name,
+    }
+}
+
+
+// MinArgs returns a Requirement which holds if at least n positional
+// arguments are found.
+func MinArgs(n int) Requirement {
+    desc := fmt.Sprintf("at least %v arguments", n)
+    if n == 1 {
+        desc = "at least 1 argument"
+    }
+    return Requirement{
+        desc: desc,
+        holds: func(parser *ArgParser) bool {
+            return len(parser.arguments) >= n
+        },
+    }
+}
+
+
 // An ArgParser instance is responsible for storing registered options and
 // commands. Note that every registered command recursively receives an
 // ArgParser instance of its own.
@@ -336,9 +1381,16 @@ type ArgParser struct {
     // Help text for the application or command.
     helptext string
 
+    // Optional text printed after the help text, e.g. examples or links.
+    epilog string
+
     // Application version number.
     version string
 
+    // Optional build metadata printed by the --version flag.
+    commit string
+    buildDate string
+
     // Stores option objects indexed by option name.
     options map[string]*option
 
@@ -359,13 +1411,150 @@ type ArgParser struct {
 
     // Stores a command parser's parent parser instance.
     parent *ArgParser
+
+    // Stores a command parser's aliases in registration order.
+    names []string
+
+    // The heading under which a command is listed in generated help text,
+    // and the parser's command categories in order of first use.
+    category string
+    categories []string
+
+    // If true, option parsing stops at the first positional argument.
+    posix bool
+
+    // If true, single-dash arguments are looked up as whole option names
+    // before being split into condensed short options.
+    singleDashLong bool
+
+    // If true, options found after a positional argument are an error.
+    optsFirst bool
+
+    // If true, integers are always parsed as base 10.
+    decimalInts bool
+
+    // Format of error messages printed on exit. Nil means inherit from the
+    // parent.
+    errorFormat *int
+
+    // If true, parsing stops at the first unrecognised option.
+    partial bool
+
+    // The stream most recently parsed, and whether its parse has been
+    // completed.
+    stream *ArgStream
+    finished bool
+
+    // If true, the automatic --debug-args flag is active for the parser and
+    // its commands.
+    debugArgs bool
+
+    // If true, references to other options in string values are substituted
+    // after parsing.
+    valueTemplating bool
+
+    // Maximum time to wait when reading from stdin. Zero means no limit.
+    stdinTimeout time.Duration
+
+    // Optional source of commands not registered on the parser.
+    cmdProvider func(string) (*ArgParser, func(*ArgParser), bool)
+
+    // Optional source of suggestions for an unrecognised command name. Nil
+    // means inherit from the parent.
+    cmdSuggester func(string, []string) []string
+
+    // Optional callback run on the root parser if no command is found.
+    rootAction func(*ArgParser)
+
+    // Middleware wrapping the callbacks of commands dispatched by the parser
+    // and its descendants.
+    middleware []middleware
+
+    // Optional handler for unrecognised options.
+    unknownHandler func(string, *ArgStream) error
+
+    // Unrecognised options collected in passthrough mode.
+    unknown []string
+
+    // Optional prefix stripped from long-form option names before lookup.
+    stripPrefix string
+
+    // If non-empty, the name of the flag after which no options may appear.
+    terminalFlag string
+
+    // If true, registering an option under a taken name replaces the
+    // existing option rather than panicking.
+    allowOverride bool
+
+    // Shortcut names standing in for another option and a value.
+    valueAliases map[string]valueAlias
+
+    // Destination for warnings about ambiguous greedy lists, if turned on.
+    ambiguityOut io.Writer
+
+    // Optional line printed after the error message when parsing fails.
+    usageFooter *string
+
+    // Optional replacement for the 'help' keyword of the help command.
+    helpKeyword *string
+
+    // If false, the automatic help and version handlers record the request
+    // instead of printing and exiting.
+    autoExit *bool
+    helpRequested bool
+    versionRequested bool
+
+    // Functions writing option values through bound pointers, run once
+    // parsing is complete.
+    bindings []func()
+
+    // Duration of the most recent parse, excluding time spent in command
+    // callbacks and the root action, which is accumulated in callbackTime.
+    parseDuration time.Duration
+    callbackTime time.Duration
+
+    // If non-empty, the name of the option which loads arguments from a
+    // file.
+    argsFileOpt string
+
+    // If non-empty, the directory against which relative paths are resolved.
+    pathBase string
+
+    // If true, a positional argument matching no paths is a globbing error.
+    globStrict bool
+
+    // Configuration values keyed by option name, and the order in which
+    // value sources are consulted. A list option may have several values.
+    config map[string][]string
+    sourceOrder []Source
+
+    // Destination for help text. Defaults to the parser's standard output.
+    helpOut io.Writer
+
+    // Destinations for standard and error output. Nil means inherit from the
+    // parent, falling back to stdout and stderr.
+    out io.Writer
+    errOut io.Writer
+
+    // Conditional requirements between options, checked after parsing.
+    dependencies []dependency
+
+    // Groups of requirements of which exactly one must hold.
+    oneOfs [][]Requirement
+
+    // Optional validator for the positional arguments, run after parsing.
+    argsValidator func([]string) error
+
+    // Placeholder for the positional arguments in generated help text.
+    argsMetavar string
 }
 
 
 // NewParser initializes a new ArgParser instance. Supplying help text
 // activates an automatic --help flag, supplying a version string activates
 // an automatic --version flag. An empty string may be passed for either
-// parameter.
+// parameter. Without help text, describing an option with Desc() activates
+// the --help flag, which then prints help text generated by BuildHelp().
 func NewParser(helptext string, version string) *ArgParser {
     return &ArgParser {
         helptext: strings.TrimSpace(helptext),
@@ -374,538 +1563,4032 @@ func NewParser(helptext string, version string) *ArgParser {
         commands: make(map[string]*ArgParser),
         callbacks: make(map[string]cmdCallback),
         arguments: make([]string, 0),
+        posix: os.Getenv("POSIXLY_CORRECT") != "",
     }
 }
 
 
-// -------------------------------------------------------------------------
-// ArgParser: registering options.
-// -------------------------------------------------------------------------
+// POSIXMode turns on strict POSIX parsing: the first positional argument
+// ends option parsing and every subsequent argument is treated as a
+// positional argument. This mode is activated automatically if the
+// POSIXLY_CORRECT environment variable is set.
+//
+// Command names are not treated as positional arguments. A command found
+// before the first positional argument is dispatched as normal and its
+// arguments are parsed by its own sub-parser, which inherits POSIX mode
+// from its parent.
+func (parser *ArgParser) POSIXMode() {
+    parser.posix = true
+}
 
 
-// AddFlag registers a boolean option.
-func (parser *ArgParser) AddFlag(name string) {
-    opt := newFlag(false)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
-    }
+// SingleDashLongOptions allows long option names to be used with a single
+// dash, e.g. -verbose, in the style of Go's own flag package. If the text
+// following the dash matches a registered option name, that option is used;
+// otherwise the argument is treated as a cluster of short options as
+// normal. Command parsers inherit this mode from their parent.
+func (parser *ArgParser) SingleDashLongOptions() {
+    parser.singleDashLong = true
 }
 
 
-// AddStr registers a string option with a default value.
-func (parser *ArgParser) AddStr(name string, value string) {
-    opt := newStr(value)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
-    }
+// DecimalIntsOnly specifies that integers - option values and positional
+// arguments parsed as integers - should always be parsed as base 10. By
+// default the base is implied by the value's prefix, so 0x10 is parsed as
+// hexadecimal and, more surprisingly, 010 as octal. Command parsers inherit
+// this mode from their parent.
+func (parser *ArgParser) DecimalIntsOnly() {
+    parser.decimalInts = true
 }
 
 
-// AddInt registers an integer option with a default value.
-func (parser *ArgParser) AddInt(name string, value int) {
-    opt := newInt(value)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
+// Returns true if the parser or one of its ancestors parses integers as
+// decimal only.
+func (parser *ArgParser) decimalOnly() bool {
+    for p := parser; p != nil; p = p.parent {
+        if p.decimalInts {
+            return true
+        }
     }
+    return false
 }
 
 
-// AddFloat registers a floating-point option with a default value.
-func (parser *ArgParser) AddFloat(name string, value float64) {
-    opt := newFloat(value)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
+// SetErrorFormat sets the format of the error message printed when parsing
+// fails and the application exits, including failures reported by methods
+// such as GetArgsAsInts() after parsing. The default, ErrorFormatText, prints
+// a human-readable message. ErrorFormatJSON prints a single line of JSON
+// instead, for tools invoked by other programs:
+//
+//     {"error":"missing argument for --name","option":"--name"}
+//
+// The option field is empty if the failure involved no single option.
+// Command parsers inherit the format from their parent.
+func (parser *ArgParser) SetErrorFormat(format int) {
+    if format != ErrorFormatText && format != ErrorFormatJSON {
+        panic(fmt.Sprintf("clio: invalid error format %v", format))
     }
+    parser.errorFormat = &format
 }
 
 
-// AddFlagList registers a boolean list option.
-func (parser *ArgParser) AddFlagList(name string) {
-    opt := newFlagList()
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
+// Returns the error format in force for the parser.
+func (parser *ArgParser) getErrorFormat() int {
+    for p := parser; p != nil; p = p.parent {
+        if p.errorFormat != nil {
+            return *p.errorFormat
+        }
     }
+    return ErrorFormatText
 }
 
 
-// AddStrList registers a string list option.
-func (parser *ArgParser) AddStrList(name string, greedy bool) {
-    opt := newStrList(greedy)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
+// Print an error to the parser's error output in its error format and exit
+// with an error code. The footer is printed after a text message only.
+func (parser *ArgParser) exitWithError(err *ParseError, footer string) {
+    if parser.getErrorFormat() == ErrorFormatJSON {
+        line, _ := json.Marshal(struct {
+            Error string `json:"error"`
+            Option string `json:"option"`
+        }{err.Message, err.Option})
+        fmt.Fprintln(parser.errWriter(), string(line))
+        osExit(1)
     }
+    exitWithFooter(parser.errWriter(), err.Message, footer)
 }
 
 
-// AddIntList registers an integer list option.
-func (parser *ArgParser) AddIntList(name string, greedy bool) {
-    opt := newIntList(greedy)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
-    }
+// Print an error message in the parser's error format and exit with an
+// error code.
+func (parser *ArgParser) exit(msg string) {
+    parser.exitWithError(&ParseError{Message: msg}, "")
 }
 
 
-// AddFloatList registers a floating-point list option.
-func (parser *ArgParser) AddFloatList(name string, greedy bool) {
-    opt := newFloatList(greedy)
-    for _, element := range strings.Split(name, " ") {
-        parser.options[element] = opt
-    }
+// OptionsBeforeArgs requires all options to precede positional arguments.
+// Once a positional argument has been found, any subsequent option is an
+// error rather than being treated as a positional argument as in POSIX mode.
+// Arguments following a -- are positional as normal. Command parsers inherit
+// this mode from their parent.
+func (parser *ArgParser) OptionsBeforeArgs() {
+    parser.optsFirst = true
 }
 
 
 // -------------------------------------------------------------------------
-// ArgParser: retrieving option values.
+// ArgParser: registering options.
 // -------------------------------------------------------------------------
 
 
-// Found returns true if the specified option was found while parsing.
-func (parser *ArgParser) Found(name string) bool {
-    return parser.options[name].found
+// Register an option under each of the space-separated aliases in name.
+// Panics if an alias is already registered as an option name or a value
+// alias, unless overriding is allowed, in which case the alias is removed
+// from its previous owner.
+func (parser *ArgParser) register(name string, opt *option) *Option {
+    opt.parser = parser
+    opt.names = strings.Split(name, " ")
+    if !parser.allowOverride {
+        for _, element := range opt.names {
+            _, isOption := parser.options[element]
+            _, isAlias := parser.valueAliases[element]
+            if isOption || isAlias {
+                panic(fmt.Sprintf("clio: option name '%v' is already registered", element))
+            }
+        }
+    }
+    for _, element := range opt.names {
+        if previous, ok := parser.options[element]; ok {
+            previous.removeName(element)
+        }
+        delete(parser.valueAliases, element)
+        parser.options[element] = opt
+    }
+    return &Option{opt}
 }
 
 
-// GetFlag returns the value of the specified boolean option.
-func (parser *ArgParser) GetFlag(name string) bool {
-    return parser.options[name].getFlag()
+// Remove a name from the option's list of names.
+func (opt *option) removeName(name string) {
+    names := make([]string, 0, len(opt.names))
+    for _, element := range opt.names {
+        if element != name {
+            names = append(names, element)
+        }
+    }
+    opt.names = names
 }
 
 
-// GetStr returns the value of the specified string option.
-func (parser *ArgParser) GetStr(name string) string {
-    return parser.options[name].getStr()
+// AllowOverride specifies whether registering an option under a name which
+// is already registered replaces the existing option. The name is removed
+// from the existing option, which keeps any other names. By default this is
+// a programming error and the registration method panics.
+func (parser *ArgParser) AllowOverride(allow bool) {
+    parser.allowOverride = allow
 }
 
 
-// GetInt returns the value of the specified integer option.
-func (parser *ArgParser) GetInt(name string) int {
-    return parser.options[name].getInt()
+// An Option is returned when an option is registered, allowing its display
+// settings to be chained, e.g.
+//
+//     parser.AddStr("out o", "-").Desc("output file").Metavar("FILE")
+//
+// The return value may be ignored.
+type Option struct {
+    opt *option
 }
 
 
-// GetFloat returns the value of the specified floating-point option.
-func (parser *ArgParser) GetFloat(name string) float64 {
-    return parser.options[name].getFloat()
+// Desc sets a short description of the option for the generated help text,
+// Markdown, man page, and completion spec.
+func (o *Option) Desc(desc string) *Option {
+    o.opt.desc = desc
+    return o
 }
 
 
-// LenList returns the length of the named option's internal list of values.
-func (parser *ArgParser) LenList(name string) int {
-    return len(parser.options[name].values)
+// Metavar sets the placeholder shown for the option's argument in generated
+// help text, e.g. FILE. By default the option's type is shown, e.g. <str>.
+func (o *Option) Metavar(metavar string) *Option {
+    o.opt.metavar = metavar
+    return o
 }
 
 
-// GetFlagList returns the named option's values as a slice of booleans.
-func (parser *ArgParser) GetFlagList(name string) []bool {
-    return parser.options[name].getFlagList()
+// AddFlag registers a boolean option.
+func (parser *ArgParser) AddFlag(name string) *Option {
+    opt := newFlag(false)
+    return parser.register(name, opt)
 }
 
 
-// GetStrList returns the named option's values as a slice of strings.
-func (parser *ArgParser) GetStrList(name string) []string {
-    return parser.options[name].getStrList()
+// AddStr registers a string option with a default value.
+func (parser *ArgParser) AddStr(name string, value string) *Option {
+    opt := newStr(value)
+    return parser.register(name, opt)
 }
 
 
-// GetIntList returns the named option's values as a slice of integers
-func (parser *ArgParser) GetIntList(name string) []int {
-    return parser.options[name].getIntList()
+// AddInt registers an integer option with a default value.
+func (parser *ArgParser) AddInt(name string, value int) *Option {
+    opt := newInt(value)
+    return parser.register(name, opt)
 }
 
 
-// GetFloatList returns the named option's values as a slice of floats.
-func (parser *ArgParser) GetFloatList(name string) []float64 {
-    return parser.options[name].getFloatList()
-}
+// AddFloat registers a floating-point option with a default value.
+func (parser *ArgParser) AddFloat(name string, value float64) *Option {
+    opt := newFloat(value)
+    return parser.register(name, opt)
+}
 
 
-// -------------------------------------------------------------------------
-// ArgParser: setting options.
-// -------------------------------------------------------------------------
+// BoolVar registers a boolean option bound to a variable. Once parsing is
+// complete, the variable holds the option's value.
+func (parser *ArgParser) BoolVar(ptr *bool, name string) *Option {
+    bound := parser.AddFlag(name)
+    opt := bound.opt
+    *ptr = false
+    parser.bindings = append(parser.bindings, func() {
+        *ptr = opt.getFlag()
+    })
+    return bound
+}
 
 
-// ClearList clears the named option's internal list of values.
-func (parser *ArgParser) ClearList(name string) {
-    parser.options[name].clear()
+// StrVar registers a string option with a default value, bound to a
+// variable. The variable is set to the default immediately and holds the
+// option's value once parsing is complete.
+func (parser *ArgParser) StrVar(ptr *string, name string, value string) *Option {
+    bound := parser.AddStr(name, value)
+    opt := bound.opt
+    *ptr = value
+    parser.bindings = append(parser.bindings, func() {
+        *ptr = opt.getStr()
+    })
+    return bound
 }
 
 
-// SetFlag appends a value to a boolean option's internal list.
-func (parser *ArgParser) SetFlag(name string, value bool) {
-    parser.options[name].setFlag(value)
+// IntVar registers an integer option with a default value, bound to a
+// variable. The variable is set to the default immediately and holds the
+// option's value once parsing is complete.
+func (parser *ArgParser) IntVar(ptr *int, name string, value int) *Option {
+    bound := parser.AddInt(name, value)
+    opt := bound.opt
+    *ptr = value
+    parser.bindings = append(parser.bindings, func() {
+        *ptr = opt.getInt()
+    })
+    return bound
 }
 
 
-// SetStr appends a value to a string option's internal list.
-func (parser *ArgParser) SetStr(name string, value string) {
-    parser.options[name].setStr(value)
+// FloatVar registers a floating-point option with a default value, bound to
+// a variable. The variable is set to the default immediately and holds the
+// option's value once parsing is complete.
+func (parser *ArgParser) FloatVar(ptr *float64, name string, value float64) *Option {
+    bound := parser.AddFloat(name, value)
+    opt := bound.opt
+    *ptr = value
+    parser.bindings = append(parser.bindings, func() {
+        *ptr = opt.getFloat()
+    })
+    return bound
 }
 
 
-// SetInt appends a value to an integer option's internal list.
-func (parser *ArgParser) SetInt(name string, value int) {
-    parser.options[name].setInt(value)
+// Bind registers an option for each field of the struct pointed to by ptr
+// which has a clio tag giving the option's names, e.g.
+//
+//     type Config struct {
+//         Port int `clio:"port p" default:"8080" min:"1" max:"65535"`
+//         Verbose bool `clio:"verbose v"`
+//     }
+//
+// Fields of type bool, string, int, and float64 are registered as flags and
+// options of the corresponding type; fields of type []string, []int, and
+// []float64 as non-greedy list options. The optional default tag sets a
+// string or numeric option's default value, otherwise the type's zero value.
+// The optional min and max tags restrict a numeric option's values as
+// SetRange() does. Scalar fields are set to their defaults immediately and
+// every bound field holds its option's value once parsing is complete.
+//
+// Returns an error naming the field if a tagged field is unexported or has
+// an unsupported type, or if one of its tags is malformed, in which case no
+// options are registered. Panics if ptr is not a pointer to a struct.
+func (parser *ArgParser) Bind(ptr interface{}) error {
+    value := reflect.ValueOf(ptr)
+    if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+        panic(fmt.Sprintf("clio: Bind requires a pointer to a struct, not %T", ptr))
+    }
+    value = value.Elem()
+
+    // Check every tagged field before registering anything.
+    specs := make([]bindSpec, 0)
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+        if _, ok := field.Tag.Lookup("clio"); !ok {
+            continue
+        }
+        spec, err := parser.newBindSpec(field, value.Field(i))
+        if err != nil {
+            return fmt.Errorf("clio: field '%v': %v", field.Name, err)
+        }
+        specs = append(specs, spec)
+    }
+
+    for _, spec := range specs {
+        parser.bindField(spec)
+    }
+    return nil
 }
 
 
-// SetFloat appends a value to a floating-point option's internal list.
-func (parser *ArgParser) SetFloat(name string, value float64) {
-    parser.options[name].setFloat(value)
+// A struct field checked for binding by Bind().
+type bindSpec struct {
+    field reflect.Value
+    name string
+    intDef int
+    floatDef float64
+    strDef string
+    min float64
+    max float64
+    ranged bool
 }
 
 
-// -------------------------------------------------------------------------
-// ArgParser: positional arguments.
-// -------------------------------------------------------------------------
+// Check a struct field's type and tags for binding.
+func (parser *ArgParser) newBindSpec(field reflect.StructField, value reflect.Value) (bindSpec, error) {
+    spec := bindSpec{field: value, min: math.Inf(-1), max: math.Inf(1)}
+    spec.name = strings.Join(strings.Fields(field.Tag.Get("clio")), " ")
+    if spec.name == "" {
+        return spec, fmt.Errorf("the clio tag is empty")
+    }
+    if field.PkgPath != "" {
+        return spec, fmt.Errorf("the field is unexported")
+    }
+
+    switch value.Interface().(type) {
+    case bool, string, int, float64, []string, []int, []float64:
+    default:
+        return spec, fmt.Errorf("unsupported type %v", field.Type)
+    }
+    elemType := field.Type
+    if elemType.Kind() == reflect.Slice {
+        elemType = elemType.Elem()
+    }
+    numeric := elemType.Kind() == reflect.Int || elemType.Kind() == reflect.Float64
 
+    if def, ok := field.Tag.Lookup("default"); ok {
+        if field.Type.Kind() == reflect.Slice || field.Type.Kind() == reflect.Bool {
+            return spec, fmt.Errorf("a default is not supported for type %v", field.Type)
+        }
+        var err error
+        switch field.Type.Kind() {
+        case reflect.String:
+            spec.strDef = def
+        case reflect.Int:
+            spec.intDef, err = parseInt(def, parser.decimalOnly())
+        case reflect.Float64:
+            spec.floatDef, err = strconv.ParseFloat(def, 64)
+        }
+        if err != nil {
+            return spec, fmt.Errorf("invalid default '%v'", def)
+        }
+    }
 
-// HasArgs returns true if the parser has found one or more positional
-// arguments.
-func (parser *ArgParser) HasArgs() bool {
-    return len(parser.arguments) > 0
+    for _, key := range []string{"min", "max"} {
+        bound, ok := field.Tag.Lookup(key)
+        if !ok {
+            continue
+        }
+        if !numeric {
+            return spec, fmt.Errorf("a %v is not supported for type %v", key, field.Type)
+        }
+        number, err := strconv.ParseFloat(bound, 64)
+        if err != nil {
+            return spec, fmt.Errorf("invalid %v '%v'", key, bound)
+        }
+        if key == "min" {
+            spec.min = number
+        } else {
+            spec.max = number
+        }
+        spec.ranged = true
+    }
+    if spec.min > spec.max {
+        return spec, fmt.Errorf("the min %v is greater than the max %v", spec.min, spec.max)
+    }
+    return spec, nil
 }
 
 
-// LenArgs returns the number of positional arguments.
-func (parser *ArgParser) LenArgs() int {
-    return len(parser.arguments)
+// Register the option for a checked struct field and bind the field to it.
+func (parser *ArgParser) bindField(spec bindSpec) {
+    var bound *Option
+    var update func(opt *option)
+    field := spec.field
+    switch field.Interface().(type) {
+    case bool:
+        bound = parser.AddFlag(spec.name)
+        update = func(opt *option) { field.SetBool(opt.getFlag()) }
+    case string:
+        bound = parser.AddStr(spec.name, spec.strDef)
+        update = func(opt *option) { field.SetString(opt.getStr()) }
+    case int:
+        bound = parser.AddInt(spec.name, spec.intDef)
+        update = func(opt *option) { field.SetInt(int64(opt.getInt())) }
+    case float64:
+        bound = parser.AddFloat(spec.name, spec.floatDef)
+        update = func(opt *option) { field.SetFloat(opt.getFloat()) }
+    case []string:
+        bound = parser.AddStrList(spec.name, false)
+        update = func(opt *option) { field.Set(reflect.ValueOf(opt.getStrList())) }
+    case []int:
+        bound = parser.AddIntList(spec.name, false)
+        update = func(opt *option) { field.Set(reflect.ValueOf(opt.getIntList())) }
+    case []float64:
+        bound = parser.AddFloatList(spec.name, false)
+        update = func(opt *option) { field.Set(reflect.ValueOf(opt.getFloatList())) }
+    }
+    opt := bound.opt
+    if spec.ranged {
+        parser.SetRange(opt.names[0], spec.min, spec.max)
+    }
+    if !opt.isList {
+        update(opt)
+    }
+    parser.bindings = append(parser.bindings, func() {
+        update(opt)
+    })
 }
 
 
-// GetArg returns the positional argument at the specified index.
-func (parser *ArgParser) GetArg(index int) string {
-    return parser.arguments[index]
+// AddScopedFlag registers a boolean option which is recognised by the parser
+// and its commands but which may only be used in combination with one of the
+// specified commands, e.g. both tool --release build and tool build
+// --release. Using the flag without one of these commands is an error.
+func (parser *ArgParser) AddScopedFlag(name string, cmds ...string) *Option {
+    opt := newFlag(false)
+    opt.scope = cmds
+    return parser.register(name, opt)
 }
 
 
-// GetArgs returns the positional arguments as a slice of strings.
-func (parser *ArgParser) GetArgs() []string {
-    return parser.arguments
+// AddIP registers an IP address option with a default value. Both IPv4 and
+// IPv6 addresses are accepted.
+func (parser *ArgParser) AddIP(name string, value net.IP) *Option {
+    opt := newIP(value)
+    return parser.register(name, opt)
 }
 
 
-// GetArgsAsInts attempts to parse and return the positional arguments as a
-// slice of integers. The application will exit with an error message if any
-// of the arguments cannot be parsed as an integer.
-func (parser *ArgParser) GetArgsAsInts() []int {
-    ints := make([]int, 0)
-    for _, strArg := range parser.arguments {
-        intArg, err := strconv.ParseInt(strArg, 0, 0)
-        if err != nil {
-            exit(fmt.Sprintf("cannot parse '%v' as an integer", strArg))
-        }
-        ints = append(ints, int(intArg))
-    }
-    return ints
+// AddURL registers a URL option with a default value, which may be nil.
+// Values must include a scheme and, except for file URLs, a host.
+func (parser *ArgParser) AddURL(name string, value *url.URL) *Option {
+    opt := newURL(value)
+    return parser.register(name, opt)
 }
 
 
-// GetArgsAsFloats attempts to parse and return the positional arguments as a
-// slice of floats. The application will exit with an error message if any
-// of the arguments cannot be parsed as a float.
-func (parser *ArgParser) GetArgsAsFloats() []float64 {
-    floats := make([]float64, 0)
-    for _, strArg := range parser.arguments {
-        floatArg, err := strconv.ParseFloat(strArg, 64)
-        if err != nil {
-            exit(fmt.Sprintf("cannot parse '%v' as a float", strArg))
+// AddSharedStr registers a string option with a default value on the parser
+// and on each of the named commands, which must already be registered. If
+// the option is not found on a command's command line, reading it from the
+// command's parser returns the parent's value, so --output can be given
+// either before or after the command name, the latter taking precedence.
+func (parser *ArgParser) AddSharedStr(cmdNames []string, name, value string) *Option {
+    parent := newStr(value)
+    shared := parser.register(name, parent)
+    for _, cmdName := range cmdNames {
+        cmdParser, ok := parser.commands[cmdName]
+        if !ok {
+            panic(fmt.Sprintf("clio: '%v' is not a registered command", cmdName))
         }
-        floats = append(floats, floatArg)
+        opt := newStr(value)
+        opt.fallback = parent
+        cmdParser.register(name, opt)
     }
-    return floats
+    return shared
 }
 
 
-// ClearArgs clears the list of positional arguments.
-func (parser *ArgParser) ClearArgs() {
-    parser.arguments = nil
+// AddToggle registers a toggle flag with a default value. Each occurrence of
+// the flag flips its current value, so --foo --foo returns it to its
+// default.
+func (parser *ArgParser) AddToggle(name string, value bool) *Option {
+    opt := newFlag(value)
+    opt.toggle = true
+    return parser.register(name, opt)
 }
 
 
-// AppendArg appends a string to the list of positional arguments.
-func (parser *ArgParser) AppendArg(arg string) {
-    parser.arguments = append(parser.arguments, arg)
+// AddFlagNegatable registers a flag with a default value which can be turned
+// on or off, e.g. --verbose and --no-verbose for the name "verbose v". The
+// last occurrence on the command line wins. Negation is only available in
+// long form.
+func (parser *ArgParser) AddFlagNegatable(name string, value bool) *Option {
+    opt := newFlag(value)
+    opt.negatable = true
+    return parser.register(name, opt)
 }
 
 
-// -------------------------------------------------------------------------
-// ArgParser: commands.
-// -------------------------------------------------------------------------
+// A valueAlias stores the option and value a shortcut name stands in for.
+type valueAlias struct {
+    target string
+    value string
+}
 
 
-// AddCmd registers a command, its help text, and its associated callback
-// function. The callback function should accept the command's ArgParser
-// instance as its sole agument and should have no return value.
-func (parser *ArgParser) AddCmd(name, helptext string, callback func(*ArgParser)) *ArgParser {
-    cmdParser := NewParser(helptext, "")
-    cmdParser.parent = parser
-    for _, element := range strings.Split(name, " ") {
-        parser.commands[element] = cmdParser
-        parser.callbacks[element] = callback
+// AddValueAlias registers a shortcut name which stands in for another option
+// and a value, e.g. AddValueAlias("verbose", "log-level", "debug") makes
+// --verbose equivalent to --log-level debug. The value is parsed according
+// to the target option's type when the alias is found. Single-character
+// aliases are used with a single dash and may be condensed like flags.
+// Panics if the alias is already registered as an option name, or as another
+// alias unless overriding is allowed.
+func (parser *ArgParser) AddValueAlias(alias, target, value string) {
+    if _, ok := parser.options[target]; !ok {
+        panic(fmt.Sprintf("clio: '%v' is not a registered option", target))
     }
-    return cmdParser
+    if _, ok := parser.options[alias]; ok {
+        panic(fmt.Sprintf("clio: option name '%v' is already registered", alias))
+    }
+    if _, ok := parser.valueAliases[alias]; ok && !parser.allowOverride {
+        panic(fmt.Sprintf("clio: option name '%v' is already registered", alias))
+    }
+    if parser.valueAliases == nil {
+        parser.valueAliases = make(map[string]valueAlias)
+    }
+    parser.valueAliases[alias] = valueAlias{target, value}
 }
 
 
-// HasCmd returns true if the parser has found a command.
-func (parser *ArgParser) HasCmd() bool {
-    return parser.cmdName != ""
+// Set the target option of a value alias to the alias's value.
+func (parser *ArgParser) applyValueAlias(alias valueAlias) {
+    opt := parser.options[alias.target]
+    opt.found = true
+    opt.trySet(alias.value)
 }
 
 
-// GetCmd returns the command name, if the parser has found a command.
-func (parser *ArgParser) GetCmdName() string {
-    return parser.cmdName
+// AddByteDelta registers a signed byte-size option with a default value, for
+// relative changes like --adjust -500MB or --adjust +1GB. Values consist of
+// an optional sign, an integer, and a required suffix: B, KB, MB, GB, or TB
+// for powers of 1000; KiB, MiB, GiB, or TiB for powers of 1024. Suffixes
+// are matched ignoring case.
+func (parser *ArgParser) AddByteDelta(name string, value int64) *Option {
+    opt := newByteDelta(value)
+    return parser.register(name, opt)
 }
 
 
-// GetCmdParser returns the command's parser instance, if a command was found.
-func (parser *ArgParser) GetCmdParser() *ArgParser {
-    return parser.cmdParser
+// AddConfirm registers a confirmation flag, e.g. "yes y" or "force f",
+// guarding a destructive action. If the flag is absent once the parser has
+// finished parsing its arguments - for a command parser, before the
+// command's callback is run - the user is shown the prompt and asked to
+// confirm. A negative answer aborts with an error. If stdin is not a
+// terminal the flag is required.
+func (parser *ArgParser) AddConfirm(name, prompt string) *Option {
+    opt := newFlag(false)
+    opt.prompt = prompt
+    return parser.register(name, opt)
 }
 
 
-// GetParent returns a command parser's parent parser instance.
-func (parser *ArgParser) GetParent() *ArgParser {
-    return parser.parent
+// AddCounter registers a counting flag, e.g. for verbosity levels. Each
+// occurrence of the flag increments its count, whether condensed, e.g.
+// -vvv, or repeated, e.g. --verbose --verbose. An explicit value sets the
+// count directly, e.g. --verbose=3. Use GetCount() to retrieve the count.
+// A counter is a boolean list option under another name.
+func (parser *ArgParser) AddCounter(name string) *Option {
+    return parser.AddFlagList(name)
 }
 
 
-// -------------------------------------------------------------------------
-// ArgParser: parsing arguments.
-// -------------------------------------------------------------------------
+// AddFlagList registers a boolean list option. Each occurrence of the flag
+// appends a value, so its length counts occurrences, e.g. -vvv. An explicit
+// value sets the count directly, e.g. --verbose=3.
+func (parser *ArgParser) AddFlagList(name string) *Option {
+    opt := newFlagList()
+    return parser.register(name, opt)
+}
 
 
-// Parses a stream of string arguments.
-func (parser *ArgParser) parseStream(stream *argStream) {
+// AddStrList registers a string list option.
+func (parser *ArgParser) AddStrList(name string, greedy bool) *Option {
+    opt := newStrList(greedy)
+    return parser.register(name, opt)
+}
 
-    // Switch to turn off option parsing if we encounter a double dash.
-    // Everything following the '--' will be treated as a positional
-    // argument.
-    parsing := true
 
-    // Loop while we have arguments to process.
-    for stream.hasNext() {
+// AddIntList registers an integer list option.
+func (parser *ArgParser) AddIntList(name string, greedy bool) *Option {
+    opt := newIntList(greedy)
+    return parser.register(name, opt)
+}
 
-        // Fetch the next argument from the stream.
-        arg := stream.next()
 
-        // If parsing has been turned off, simply add the argument to the
-        // list of positionals.
-        if !parsing {
-            parser.arguments = append(parser.arguments, arg)
-            continue
-        }
+// AddFloatList registers a floating-point list option.
+func (parser *ArgParser) AddFloatList(name string, greedy bool) *Option {
+    opt := newFloatList(greedy)
+    return parser.register(name, opt)
+}
 
-        // If we encounter a -- argument, turn off option-parsing.
-        if arg == "--" {
-            parsing = false
-            continue
-        }
 
-        // Is the argument a long-form option or flag?
-        if strings.HasPrefix(arg, "--") {
-            parser.parseLongOption(arg[2:], stream)
-            continue
-        }
+// AddIPList registers an IP address list option.
+func (parser *ArgParser) AddIPList(name string, greedy bool) *Option {
+    opt := newIPList(greedy)
+    return parser.register(name, opt)
+}
 
-        // Is the argument a short-form option or flag? If the argument
-        // consists of a single dash or a dash followed by a digit, we treat
-        // it as a positional argument.
-        if strings.HasPrefix(arg, "-") {
-            if arg == "-" || unicode.IsDigit([]rune(arg)[1]) {
-                parser.arguments = append(parser.arguments, arg)
-            } else {
-                parser.parseShortOption(arg[1:], stream)
-            }
-            continue
-        }
 
-        // Is the argument a registered command?
-        if cmdParser, ok := parser.commands[arg]; ok {
-            parser.cmdName = arg
-            parser.cmdParser = cmdParser
-            cmdParser.parseStream(stream)
-            parser.callbacks[arg](cmdParser)
-            continue
-        }
+// AddEnumList registers a string list option whose values must be drawn from
+// the specified choices, e.g. --permissions read,write. Values may be
+// supplied as a comma-separated list, by repeating the option, or both.
+func (parser *ArgParser) AddEnumList(name string, choices []string, greedy bool) *Option {
+    opt := newStrList(greedy)
+    opt.choices = choices
+    opt.delimiter = ","
+    return parser.register(name, opt)
+}
 
-        // Is the argument the automatic 'help' command?
-        if arg == "help" {
-            if stream.hasNext() {
-                name := stream.next()
-                if cmdParser, ok := parser.commands[name]; ok {
-                    fmt.Println(cmdParser.helptext)
-                    os.Exit(0)
-                } else {
-                    exit(fmt.Sprintf("'%v' is not a recognised command", name))
-                }
-            } else {
-                exit("the help command requires an argument")
-            }
-        }
 
-        // If we get here, we have a positional argument.
-        parser.arguments = append(parser.arguments, arg)
+// AddStrChoices registers a string option whose value must be one of the
+// specified choices, e.g. --format json. The choices are listed in the
+// generated documentation. Panics if the default value is not one of the
+// choices.
+func (parser *ArgParser) AddStrChoices(name string, value string, choices []string) *Option {
+    if !contains(choices, value) {
+        panic(fmt.Sprintf(
+            "clio: the default value '%v' for '%v' is not one of its choices",
+            value,
+            name,
+        ))
     }
+    opt := newStr(value)
+    opt.choices = choices
+    return parser.register(name, opt)
 }
 
 
-// ParseArgs parses a slice of string arguments.
-func (parser *ArgParser) ParseArgs(args []string) {
-    parser.parseStream(newArgStream(args))
+// SetEnumCaseInsensitive specifies that values for the named enum option
+// should be matched ignoring case. Matching values are normalized to the
+// canonical form of the choice, e.g. with the choice "json" the argument
+// JSON is stored as "json". Panics if the option is not an enum.
+func (parser *ArgParser) SetEnumCaseInsensitive(name string) {
+    opt := parser.lookupOption(name)
+    if len(opt.choices) == 0 {
+        panic(fmt.Sprintf("clio: case-insensitive matching requires an enum option, '%v' is not one", name))
+    }
+    opt.foldChoices = true
 }
 
 
-// Parse parses the application's command line arguments.
-func (parser *ArgParser) Parse() {
-    parser.ParseArgs(os.Args[1:])
+// AddStrSet registers a string set option, e.g. --feature x --feature y.
+// This is a non-greedy string list which ignores duplicate values. Use
+// HasSetMember() to test for a value.
+func (parser *ArgParser) AddStrSet(name string) *Option {
+    opt := newStrList(false)
+    opt.unique = true
+    return parser.register(name, opt)
 }
 
 
-// Parse a long-form option, i.e. an option beginning with a double dash.
-func (parser *ArgParser) parseLongOption(arg string, stream *argStream) {
+// AddIndexed registers an indexed option for configuring lists of structured
+// values, e.g. --server.0.host a --server.0.port 80 --server.1.host b. Each
+// argument has the form --name.N.field where N is a non-negative integer
+// index. The option must be used in its long form.
+func (parser *ArgParser) AddIndexed(name string) *Option {
+    opt := newIndexed()
+    return parser.register(name, opt)
+}
 
-    // Do we have an option of the form --name=value?
-    if strings.Contains(arg, "=") {
-        parser.parseEqualsOption("--", arg)
-        return
+
+// AddMap registers a string map option for values of the form key=value,
+// e.g. -D name=foo -D level=2. Each occurrence of the option supplies a
+// single pair; the value may be empty or contain further '=' characters.
+func (parser *ArgParser) AddMap(name string) *Option {
+    opt := newMap()
+    return parser.register(name, opt)
+}
+
+
+// AddPairs registers an option for values of the form key<sep>value, e.g.
+// -H Accept:text/html -H Accept:text/plain with the separator ':'. Unlike a
+// map option, every pair is kept in order, including pairs with repeated
+// keys. Each occurrence of the option supplies a single pair; the value may
+// be empty or contain further separators. Panics if sep is empty.
+func (parser *ArgParser) AddPairs(name string, sep string) *Option {
+    if sep == "" {
+        panic(fmt.Sprintf("clio: the pairs option '%v' requires a separator", name))
     }
+    opt := newPairs(sep)
+    return parser.register(name, opt)
+}
 
-    // Is the argument a registered option name?
-    if opt, ok := parser.options[arg]; ok {
-        opt.found = true
 
-        // If the option is a flag, store the boolean true.
-        if opt.optType == flagOpt {
-            opt.setFlag(true)
-            return
-        }
+// AddIntMap registers an integer map option for values of the form
+// key=value,key=value, e.g. --limits cpu=2,mem=4. The option may be
+// repeated; its values are merged.
+func (parser *ArgParser) AddIntMap(name string) *Option {
+    opt := newIntMap()
+    return parser.register(name, opt)
+}
 
-        // Not a flag, so check for a following option value.
-        if !stream.hasNextValue() {
-            exit(fmt.Sprintf("missing argument for --%v", arg))
-        }
 
-        // Try to parse the argument as a value of the appropriate type.
-        opt.trySet(stream.next())
+// SetAllowedSchemes restricts the named URL option to the specified schemes.
+// Schemes are compared case-insensitively.
+func (parser *ArgParser) SetAllowedSchemes(name string, schemes ...string) {
+    parser.lookupOption(name).schemes = schemes
+}
 
-        // If the option is a greedy list, keep trying to parse values
-        // until we run out of arguments.
-        if opt.greedy {
-            for stream.hasNextValue() {
-                opt.trySet(stream.next())
-            }
-        }
-        return
-    }
 
-    // Is the argument the automatic --help flag?
-    if arg == "help" && parser.helptext != "" {
-        fmt.Println(parser.helptext)
-        os.Exit(0)
+// SetValuePredicate overrides the test used to decide whether the argument
+// following the named option counts as one of its values. By default,
+// arguments beginning with a dash are treated as option names unless they
+// consist of a single dash or a dash followed by a digit. The predicate
+// receives the candidate argument and should return true to consume it.
+func (parser *ArgParser) SetValuePredicate(name string, fn func(token string) bool) {
+    parser.lookupOption(name).isValue = fn
+}
+
+
+// SetTrailingCapture turns the named string list option into a capturing
+// option: once it is found, normal parsing stops and every remaining
+// argument is appended to its list of values, e.g. to collect a command and
+// its arguments for a sub-process. Panics if the option is not a string
+// list.
+func (parser *ArgParser) SetTrailingCapture(name string) {
+    opt := parser.lookupOption(name)
+    if opt.optType != strOpt || !opt.isList {
+        panic(fmt.Sprintf("clio: trailing capture requires a string list option, '%v' is not one", name))
     }
+    opt.capture = true
+}
 
-    // Is the argument the automatic --version flag?
-    if arg == "version" && parser.version != "" {
-        fmt.Println(parser.version)
-        os.Exit(0)
+
+// SetDelimiter specifies a delimiter for the named list option. Each value
+// supplied on the command line is split on the delimiter and the pieces are
+// appended individually, so --tags a,b,c and --tags=a,b,c both yield three
+// values. A delimiter can be included in a value by escaping it with a
+// backslash, e.g. a\,b. A doubled backslash, \\, stands for a single
+// literal backslash. Panics if the option is not a list.
+func (parser *ArgParser) SetDelimiter(name string, delimiter string) {
+    opt := parser.lookupOption(name)
+    if !opt.isList {
+        panic(fmt.Sprintf("clio: a delimiter requires a list option, '%v' is not one", name))
     }
+    opt.delimiter = delimiter
+}
 
-    // The argument is not a registered or automatic option name.
-    // Print an error message and exit.
-    exit(fmt.Sprintf("--%v is not a recognised option", arg))
+
+// SetCSV specifies that values of the named list option are comma-separated,
+// so -i=1,2,3 or -i 1,2,3 yields three values. It's equivalent to
+// SetDelimiter() with a comma. Panics if the option is not a list.
+func (parser *ArgParser) SetCSV(name string) {
+    parser.SetDelimiter(name, ",")
 }
 
 
-// Parse a short-form option, i.e. an option beginning with a single dash.
-func (parser *ArgParser) parseShortOption(arg string, stream *argStream) {
+// SetListValidator registers a function to validate each value of the named
+// integer list option as it's parsed, e.g. to check that it's a valid port
+// number. If the function returns an error, the application will exit with
+// an error message naming the value. Validators registered with this method
+// or with SetRange() are combined rather than replaced, each value being
+// checked by each in turn. Panics if the option is not an integer list.
+func (parser *ArgParser) SetListValidator(name string, fn func(i int) error) {
+    opt := parser.listOfType(name, intOpt)
+    opt.addValidator(func(value optionValue) error {
+        return fn(value.intVal)
+    })
+}
 
-    // Do we have an option of the form -n=value?
-    if strings.Contains(arg, "=") {
-        parser.parseEqualsOption("-", arg)
-        return
-    }
 
-    // We handle each character individually to support condensed options:
-    //    -abc foo bar
-    // is equivalent to:
-    //    -a foo -b bar -c
-    for _, char := range arg {
-        name := string(char)
+// SetStrListValidator registers a function to validate each value of the
+// named string list option as it's parsed. Panics if the option is not a
+// string list.
+func (parser *ArgParser) SetStrListValidator(name string, fn func(s string) error) {
+    opt := parser.listOfType(name, strOpt)
+    opt.addValidator(func(value optionValue) error {
+        return fn(value.strVal)
+    })
+}
 
-        // Do we have the name of a registered option?
-        if opt, ok := parser.options[name]; ok {
-            opt.found = true
 
-            // If the option is a flag, store the boolean true.
-            if opt.optType == flagOpt {
-                opt.setFlag(true)
-                continue
-            }
+// SetFloatListValidator registers a function to validate each value of the
+// named floating-point list option as it's parsed. Panics if the option is
+// not a floating-point list.
+func (parser *ArgParser) SetFloatListValidator(name string, fn func(f float64) error) {
+    opt := parser.listOfType(name, floatOpt)
+    opt.addValidator(func(value optionValue) error {
+        return fn(value.floatVal)
+    })
+}
 
-            // Not a flag, so check for a following option value.
-            if !stream.hasNextValue() {
-                exit(fmt.Sprintf("missing argument for the -%v option", name))
-            }
 
-            // Try to parse the argument as a value of the appropriate type.
-            opt.trySet(stream.next())
+// SetRange restricts the values of the named integer or floating-point
+// option, or list option, to the range min to max inclusive. Use math.Inf()
+// for an open bound. A value outside the range is an error. Panics if the
+// option is not numeric.
+func (parser *ArgParser) SetRange(name string, min, max float64) {
+    opt := parser.lookupOption(name)
+    if opt.optType != intOpt && opt.optType != floatOpt {
+        panic(fmt.Sprintf("clio: a range requires a numeric option, '%v' is not one", name))
+    }
+    opt.addValidator(func(value optionValue) error {
+        number := value.floatVal
+        if opt.optType == intOpt {
+            number = float64(value.intVal)
+        }
+        if number >= min && number <= max {
+            return nil
+        }
+        switch {
+        case math.IsInf(max, 1):
+            return fmt.Errorf("must be at least %v", min)
+        case math.IsInf(min, -1):
+            return fmt.Errorf("must be at most %v", max)
+        }
+        return fmt.Errorf("must be between %v and %v", min, max)
+    })
+}
 
-            // If the option is a greedy list, keep trying to parse values
-            // until we run out of arguments.
-            if opt.greedy {
-                for stream.hasNextValue() {
-                    opt.trySet(stream.next())
-                }
-            }
 
-        // Not a registered option. Print a error message and exit.
-        } else {
-            exit(fmt.Sprintf("-%v is not a recognised option", name))
+// Adds a validator to an option. Validators run in the order they were
+// added, stopping at the first which returns an error.
+func (opt *option) addValidator(fn func(optionValue) error) {
+    previous := opt.validator
+    if previous == nil {
+        opt.validator = fn
+        return
+    }
+    opt.validator = func(value optionValue) error {
+        if err := previous(value); err != nil {
+            return err
         }
+        return fn(value)
     }
 }
 
 
-// Parse an option of the form --name=value or -n=value.
-func (parser *ArgParser) parseEqualsOption(prefix string, arg string) {
-    split := strings.SplitN(arg, "=", 2)
-    name := split[0]
-    value := split[1]
-
+// SetTerminalFlag specifies that the named flag closes the option section:
+// once it has been found, any further recognised option is an error. Useful
+// for a flag which switches the tool into a literal passthrough mode.
+// Panics if the option is not a flag.
+func (parser *ArgParser) SetTerminalFlag(name string) {
+    opt := parser.lookupOption(name)
+    if opt.optType != flagOpt {
+        panic(fmt.Sprintf("clio: a terminal flag requires a flag option, '%v' is not one", name))
+    }
+    parser.terminalFlag = opt.names[0]
+}
+
+
+// Returns the named option, panicking if it is not a list of the specified
+// type.
+func (parser *ArgParser) listOfType(name string, optType int) *option {
+    opt := parser.lookupOption(name)
+    if opt.optType != optType || !opt.isList {
+        typename := (&option{optType: optType}).typeName()
+        panic(fmt.Sprintf("clio: '%v' is not a %v list option", name, typename))
+    }
+    return opt
+}
+
+
+// SetDefaultFrom registers a function to compute the named string option's
+// default value when it's retrieved, if the option was not found, e.g. to
+// default --log-file to the value of --name plus ".log". The function
+// receives the parser so it can read other options. Panics if the option is
+// not a string option.
+func (parser *ArgParser) SetDefaultFrom(name string, fn func(p *ArgParser) string) {
+    opt := parser.lookupOption(name)
+    if opt.optType != strOpt || opt.isList {
+        panic(fmt.Sprintf("clio: a computed default requires a string option, '%v' is not one", name))
+    }
+    opt.defaultFrom = func() string {
+        return fn(parser)
+    }
+}
+
+
+// MarkSecret specifies that the named option holds a secret, e.g. a password
+// or token. Its values are masked as **** wherever the parser prints or
+// exports them - String(), Dump(), ConfigTable(), and the generated
+// documentation - but are returned as normal by the getters.
+func (parser *ArgParser) MarkSecret(name string) {
+    parser.lookupOption(name).secret = true
+}
+
+
+// GetSecretAndClear returns the value of the named secret string option, as
+// GetStr() does, then zeroes the stored bytes of every value parsed for it.
+// Afterwards the option's getters return an empty string in place of each
+// cleared value. Only values parsed or set after MarkSecret() are held in
+// wipeable storage; the registration default is not.
+//
+// The guarantees here are limited. Go strings are immutable, so the string
+// returned, the original command line argument in os.Args, and any copies
+// made while parsing cannot be wiped and remain in memory until the garbage
+// collector reclaims them, which may be never. Clearing only ensures that
+// the parser itself no longer holds the value, e.g. for a later Dump().
+func (parser *ArgParser) GetSecretAndClear(name string) string {
+    opt := parser.lookupOption(name)
+    if !opt.secret || opt.optType != strOpt || opt.isList {
+        panic(fmt.Sprintf("clio: '%v' is not a secret string option", name))
+    }
+    value := opt.getStr()
+    for i := range opt.values {
+        secret := opt.values[i].secretVal
+        for j := range secret {
+            secret[j] = 0
+        }
+        if secret != nil {
+            opt.values[i].secretVal = []byte{}
+        }
+    }
+    return value
+}
+
+
+// SetUnique specifies that the named list option should ignore duplicate
+// values. A value parsed from the command line is skipped if an equal value
+// is already present in the option's list, so the list preserves the order
+// of first occurrences.
+func (parser *ArgParser) SetUnique(name string) {
+    parser.lookupOption(name).unique = true
+}
+
+
+// SetAmbiguityWarning turns on warnings for greedy list options which consume
+// an argument that is also a command name, e.g. --files a b build, where
+// 'build' may have been intended as a command. The warnings are advisory -
+// parsing continues as normal - and are written to w. Command parsers
+// inherit the setting from their parent.
+func (parser *ArgParser) SetAmbiguityWarning(w io.Writer) {
+    parser.ambiguityOut = w
+}
+
+
+// SetGreedyStopAtTerminator specifies whether the named greedy list option
+// should stop at a '--' terminator. The default is true: the list consumes
+// arguments up to but not including the '--', which then turns off option
+// parsing as normal. If false, the list swallows the '--' and consumes every
+// remaining argument as a value.
+func (parser *ArgParser) SetGreedyStopAtTerminator(name string, stop bool) {
+    parser.lookupOption(name).consumeTerminator = !stop
+}
+
+
+// SetListSentinel specifies a sentinel token for the named greedy list
+// option. The list stops consuming arguments when it reaches the sentinel,
+// which is discarded, e.g. with the sentinel "++" the command line
+// --includes a b ++ --excludes c d parses as two separate lists.
+func (parser *ArgParser) SetListSentinel(name, sentinel string) {
+    parser.lookupOption(name).sentinel = sentinel
+}
+
+
+// Require specifies that the named option is mandatory. Parsing fails with
+// an error message if the option is not found on the command line and
+// receives no value from another source, e.g. an environment variable or
+// config file. The check is skipped if help or version information is
+// requested. Each command parser checks its own required options once it
+// has parsed its arguments. A command's callback is only run once the
+// command parser and its ancestors have all been checked.
+func (parser *ArgParser) Require(name string) {
+    parser.lookupOption(name).required = true
+}
+
+
+// RequireIf specifies that the option named target is required if the
+// option named cond is found on the command line, e.g. that --cert is
+// required if --tls is set. The target may be supplied by any source, e.g.
+// an environment variable. Both options must already be registered.
+func (parser *ArgParser) RequireIf(cond, target string) {
+    parser.lookupOption(cond)
+    parser.lookupOption(target)
+    parser.dependencies = append(parser.dependencies, dependency{cond, target, false})
+}
+
+
+// RequireUnless specifies that the option named target is required unless
+// the option named cond has a value. Either option may be supplied by any
+// source, e.g. an environment variable. Both options must already be
+// registered.
+func (parser *ArgParser) RequireUnless(cond, target string) {
+    parser.lookupOption(cond)
+    parser.lookupOption(target)
+    parser.dependencies = append(parser.dependencies, dependency{cond, target, true})
+}
+
+
+// RequireOneOf specifies that exactly one of the requirements must hold once
+// parsing is complete, e.g. RequireOneOf(OptionPresent("all"), MinArgs(1))
+// requires either the --all flag or at least one positional argument, but
+// not both. Options named in the requirements must already be registered.
+func (parser *ArgParser) RequireOneOf(reqs ...Requirement) {
+    for _, req := range reqs {
+        if req.option != "" {
+            parser.lookupOption(req.option)
+        }
+    }
+    parser.oneOfs = append(parser.oneOfs, reqs)
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: retrieving option values.
+// -------------------------------------------------------------------------
+
+
+// Found returns true if the specified option was found on the command line.
+// An option whose value comes from an environment variable or configuration
+// value isn't found; use SourceOf() to check where its value came from.
+func (parser *ArgParser) Found(name string) bool {
+    return parser.lookupOption(name).found
+}
+
+
+// GetFlag returns the value of the specified boolean option.
+func (parser *ArgParser) GetFlag(name string) bool {
+    return parser.lookupOption(name).getFlag()
+}
+
+
+// GetByteDelta returns the value of the specified byte delta option as a
+// signed number of bytes.
+func (parser *ArgParser) GetByteDelta(name string) int64 {
+    return parser.lookupOption(name).getByteDelta()
+}
+
+
+// GetStr returns the value of the specified string option.
+func (parser *ArgParser) GetStr(name string) string {
+    return parser.lookupOption(name).getStr()
+}
+
+
+// GetInt returns the value of the specified integer option.
+func (parser *ArgParser) GetInt(name string) int {
+    return parser.lookupOption(name).getInt()
+}
+
+
+// GetFloat returns the value of the specified floating-point option.
+func (parser *ArgParser) GetFloat(name string) float64 {
+    return parser.lookupOption(name).getFloat()
+}
+
+
+// GetIP returns the value of the specified IP address option.
+func (parser *ArgParser) GetIP(name string) net.IP {
+    return parser.lookupOption(name).getIP()
+}
+
+
+// GetURL returns the value of the specified URL option.
+func (parser *ArgParser) GetURL(name string) *url.URL {
+    return parser.lookupOption(name).getURL()
+}
+
+
+// GetFlagDefault returns the registration-time default value of the
+// specified boolean option, regardless of what was parsed.
+func (parser *ArgParser) GetFlagDefault(name string) bool {
+    return parser.lookupOption(name).getDefault().boolVal
+}
+
+
+// GetStrDefault returns the registration-time default value of the specified
+// string option, regardless of what was parsed.
+func (parser *ArgParser) GetStrDefault(name string) string {
+    return parser.lookupOption(name).getDefault().str()
+}
+
+
+// GetIntDefault returns the registration-time default value of the specified
+// integer option, regardless of what was parsed.
+func (parser *ArgParser) GetIntDefault(name string) int {
+    return parser.lookupOption(name).getDefault().intVal
+}
+
+
+// GetFloatDefault returns the registration-time default value of the
+// specified floating-point option, regardless of what was parsed.
+func (parser *ArgParser) GetFloatDefault(name string) float64 {
+    return parser.lookupOption(name).getDefault().floatVal
+}
+
+
+// Returns an option's registration-time default value. List options have no
+// default so the zero value is returned.
+func (opt *option) getDefault() optionValue {
+    if opt.def == nil {
+        return optionValue{}
+    }
+    return *opt.def
+}
+
+
+// GetValue returns the value of the specified option boxed according to its
+// type: bool for flags, string, int, or float64 for string, integer, and
+// floating-point options, net.IP, *url.URL, int64 for byte deltas, and
+// map[string]int for integer maps. Values of other types are returned as
+// strings in their command line form, e.g. key=value. For a list option the
+// last value is returned, or nil if the list is empty. A scalar string option
+// returns the same value as GetStr(), which applies any fallback option or
+// computed default; the values of other options, including string lists, are
+// returned as stored. The application will exit with an error message if no
+// option is registered under the name.
+func (parser *ArgParser) GetValue(name string) interface{} {
+    opt := parser.lookupOption(name)
+    if opt.optType == strOpt && !opt.isList {
+        return opt.getStr()
+    }
+    if len(opt.values) == 0 {
+        return nil
+    }
+    return opt.boxValue(opt.values[len(opt.values) - 1])
+}
+
+
+// GetValueList returns the values of the specified list option boxed
+// according to its type as GetValue() does. The application will exit with
+// an error message if no option is registered under the name.
+func (parser *ArgParser) GetValueList(name string) []interface{} {
+    opt := parser.lookupOption(name)
+    values := make([]interface{}, 0, len(opt.values))
+    for _, optVal := range opt.values {
+        values = append(values, opt.boxValue(optVal))
+    }
+    return values
+}
+
+
+// Returns a single value boxed according to the option's type.
+func (opt *option) boxValue(value optionValue) interface{} {
+    switch opt.optType {
+    case flagOpt:
+        return value.boolVal
+    case strOpt:
+        return value.str()
+    case intOpt:
+        return value.intVal
+    case floatOpt:
+        return value.floatVal
+    case ipOpt:
+        return value.ipVal
+    case urlOpt:
+        return value.urlVal
+    case byteDeltaOpt:
+        return value.bytesVal
+    case intMapOpt:
+        return value.intMap
+    }
+    return opt.formatValue(value)
+}
+
+
+// Returns the option registered under the specified name. Exits with an
+// error message if there is no such option.
+func (parser *ArgParser) lookupOption(name string) *option {
+    opt, ok := parser.options[name]
+    if !ok {
+        parser.exit(fmt.Sprintf("no option registered under the name '%v'", name))
+    }
+    return opt
+}
+
+
+// LenList returns the length of the named option's internal list of values.
+func (parser *ArgParser) LenList(name string) int {
+    return len(parser.lookupOption(name).values)
+}
+
+
+// GetCount returns the number of times the named counter or boolean list
+// option appeared on the command line, or zero if it was absent. Panics if
+// the option is not a boolean list.
+func (parser *ArgParser) GetCount(name string) int {
+    opt := parser.lookupOption(name)
+    if opt.optType != flagOpt || !opt.isList {
+        panic(fmt.Sprintf("clio: '%v' is not a counter", name))
+    }
+    return len(opt.values)
+}
+
+
+// GetFlagList returns the named option's values as a slice of booleans.
+func (parser *ArgParser) GetFlagList(name string) []bool {
+    return parser.lookupOption(name).getFlagList()
+}
+
+
+// GetStrList returns the named option's values as a slice of strings.
+func (parser *ArgParser) GetStrList(name string) []string {
+    return parser.lookupOption(name).getStrList()
+}
+
+
+// GetPath returns the value of the specified string option as a file path. A
+// leading ~ is expanded to the user's home directory and, if a base
+// directory has been set using SetPathBase(), a relative path is resolved
+// against it. An empty value is returned unchanged.
+func (parser *ArgParser) GetPath(name string) string {
+    path := parser.lookupOption(name).getStr()
+    if path == "" {
+        return path
+    }
+    if path == "~" || strings.HasPrefix(path, "~/") {
+        if home, err := os.UserHomeDir(); err == nil {
+            path = filepath.Join(home, path[1:])
+        }
+    }
+    if base := parser.getPathBase(); base != "" && !filepath.IsAbs(path) {
+        path = filepath.Join(base, path)
+    }
+    return path
+}
+
+
+// SetPathBase sets the directory against which GetPath() resolves relative
+// paths, e.g. the directory containing the application's config file.
+// Absolute paths are unaffected. By default relative paths are returned as
+// is, i.e. relative to the working directory. Command parsers inherit their
+// parent's base unless they set their own.
+func (parser *ArgParser) SetPathBase(dir string) {
+    parser.pathBase = dir
+}
+
+
+// Returns the base directory for relative paths, inherited from the parser's
+// parent if not set.
+func (parser *ArgParser) getPathBase() string {
+    for p := parser; p != nil; p = p.parent {
+        if p.pathBase != "" {
+            return p.pathBase
+        }
+    }
+    return ""
+}
+
+
+// GetStrListJoined returns the named option's values joined into a single
+// string with the specified separator. Returns an empty string if the list
+// is empty.
+func (parser *ArgParser) GetStrListJoined(name, sep string) string {
+    return strings.Join(parser.lookupOption(name).getStrList(), sep)
+}
+
+
+// GetIntList returns the named option's values as a slice of integers
+func (parser *ArgParser) GetIntList(name string) []int {
+    return parser.lookupOption(name).getIntList()
+}
+
+
+// GetFloatList returns the named option's values as a slice of floats.
+func (parser *ArgParser) GetFloatList(name string) []float64 {
+    return parser.lookupOption(name).getFloatList()
+}
+
+
+// GetIPList returns the named option's values as a slice of IP addresses.
+func (parser *ArgParser) GetIPList(name string) []net.IP {
+    return parser.lookupOption(name).getIPList()
+}
+
+
+// GetSet returns the named set option's members in the order in which they
+// were first found.
+func (parser *ArgParser) GetSet(name string) []string {
+    return parser.lookupOption(name).getStrList()
+}
+
+
+// HasSetMember returns true if the named set option contains the specified
+// value.
+func (parser *ArgParser) HasSetMember(name, value string) bool {
+    opt := parser.lookupOption(name)
+    return opt.hasValue(optionValue{strVal: value})
+}
+
+
+// GetIndexed returns the named indexed option's values grouped by index, with
+// each group mapping field names to values. Indices skipped on the command
+// line are represented by empty maps.
+func (parser *ArgParser) GetIndexed(name string) []map[string]string {
+    return parser.lookupOption(name).getIndexed()
+}
+
+
+// GetIntMap returns the named integer map option's values as a map. Where a
+// key is repeated the last value wins.
+func (parser *ArgParser) GetIntMap(name string) map[string]int {
+    return parser.lookupOption(name).getIntMap()
+}
+
+
+// GetMap returns the named string map option's values as a map. Where a key
+// is repeated the last value wins.
+func (parser *ArgParser) GetMap(name string) map[string]string {
+    _, merged := parser.lookupOption(name).getMapOrdered()
+    return merged
+}
+
+
+// GetMapOrdered returns the named string map option's keys in the order of
+// their first appearance on the command line along with its values as a map,
+// for callers that need both views, e.g. -D style defines where later values
+// override earlier ones. Where a key is repeated the last value wins.
+func (parser *ArgParser) GetMapOrdered(name string) ([]string, map[string]string) {
+    return parser.lookupOption(name).getMapOrdered()
+}
+
+
+// GetPairs returns the named pairs option's key-value pairs in the order
+// they appeared on the command line, including pairs with repeated keys.
+func (parser *ArgParser) GetPairs(name string) [][2]string {
+    return parser.lookupOption(name).getPairs()
+}
+
+
+// UnusedAfterParse returns the primary names of options which were not found
+// while parsing and which still hold their default values (for list
+// options, no values). This is a heuristic aid for integration tests which
+// feed the parser representative command lines - an option listed here was
+// not exercised - not a guarantee that the application never reads it.
+func (parser *ArgParser) UnusedAfterParse() []string {
+    unused := make([]string, 0)
+    for _, opt := range parser.distinctOptions() {
+        if opt.found {
+            continue
+        }
+        if opt.isList && len(opt.values) > 0 {
+            continue
+        }
+        if !opt.isList && len(opt.values) > 0 && opt.def != nil {
+            if !opt.equalValues(opt.values[len(opt.values) - 1], *opt.def) {
+                continue
+            }
+        }
+        unused = append(unused, opt.names[0])
+    }
+    return unused
+}
+
+
+// Canonical returns the primary name of the option registered under the
+// specified alias, i.e. the first name in its registration string. Returns
+// an empty string if no option is registered under the alias.
+func (parser *ArgParser) Canonical(name string) string {
+    if opt, ok := parser.options[name]; ok {
+        return opt.names[0]
+    }
+    return ""
+}
+
+
+// TypeOf returns the name of the specified option's type: "flag", "str",
+// "int", "float", "ip", "url", "intmap", "indexed", "bytedelta", "map", or
+// "pairs".
+func (parser *ArgParser) TypeOf(name string) string {
+    return parser.lookupOption(name).typeName()
+}
+
+
+// IsList returns true if the specified option was registered as a list. Map
+// options aren't lists.
+func (parser *ArgParser) IsList(name string) bool {
+    opt := parser.lookupOption(name)
+    return opt.isList && !opt.isMap()
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: setting options.
+// -------------------------------------------------------------------------
+
+
+// ClearList clears the named option's internal list of values.
+func (parser *ArgParser) ClearList(name string) {
+    parser.lookupOption(name).clear()
+}
+
+
+// SetFlag appends a value to a boolean option's internal list.
+func (parser *ArgParser) SetFlag(name string, value bool) {
+    parser.lookupOption(name).setFlag(value)
+}
+
+
+// SetStr appends a value to a string option's internal list.
+func (parser *ArgParser) SetStr(name string, value string) {
+    parser.lookupOption(name).setStr(value)
+}
+
+
+// SetInt appends a value to an integer option's internal list.
+func (parser *ArgParser) SetInt(name string, value int) {
+    parser.lookupOption(name).setInt(value)
+}
+
+
+// SetFloat appends a value to a floating-point option's internal list.
+func (parser *ArgParser) SetFloat(name string, value float64) {
+    parser.lookupOption(name).setFloat(value)
+}
+
+
+// SetFromMap sets option values from a map of option names to string values
+// as if each had been supplied on the command line, i.e. each value is
+// parsed according to the option's type and the option is marked as found.
+// Keys are applied in sorted order. The application will exit with an error
+// message if a key is not a registered option name or a value cannot be
+// parsed.
+func (parser *ArgParser) SetFromMap(m map[string]string) {
+    names := make([]string, 0, len(m))
+    for name := range m {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    err := catch(func() {
+        for _, name := range names {
+            opt, ok := parser.options[name]
+            if !ok {
+                failOption(
+                    optionLabel(name),
+                    fmt.Sprintf("%v is not a recognised option", optionLabel(name)),
+                )
+            }
+            opt.found = true
+            opt.source = SourceCLI
+            opt.trySet(m[name])
+        }
+    })
+    if err != nil {
+        parser.exitWithError(err.(*ParseError), "")
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: value sources.
+// -------------------------------------------------------------------------
+
+
+// A Source identifies where an option's value came from.
+type Source int
+
+
+// Sources of option values. See SetSourceOrder().
+const (
+    SourceDefault Source = iota
+    SourceCLI
+    SourceEnv
+    SourceConfig
+)
+
+
+// String returns the source's name.
+func (source Source) String() string {
+    switch source {
+    case SourceCLI:
+        return "cli"
+    case SourceEnv:
+        return "env"
+    case SourceConfig:
+        return "config"
+    }
+    return "default"
+}
+
+
+// SetEnv specifies an environment variable supplying a value for the named
+// option. The variable is consulted after parsing according to the parser's
+// source order. Empty variables are ignored.
+func (parser *ArgParser) SetEnv(name, envVar string) {
+    parser.lookupOption(name).envVar = envVar
+}
+
+
+// AddFlagEnv registers a boolean option which falls back to the named
+// environment variable if not found on the command line, e.g. for the value
+// 'true' or '1'. It's equivalent to AddFlag() followed by SetEnv().
+func (parser *ArgParser) AddFlagEnv(name, envVar string) *Option {
+    bound := parser.AddFlag(name)
+    bound.opt.envVar = envVar
+    return bound
+}
+
+
+// AddStrEnv registers a string option with a default value which falls back
+// to the named environment variable if not found on the command line. It's
+// equivalent to AddStr() followed by SetEnv().
+func (parser *ArgParser) AddStrEnv(name, value, envVar string) *Option {
+    bound := parser.AddStr(name, value)
+    bound.opt.envVar = envVar
+    return bound
+}
+
+
+// AddIntEnv registers an integer option with a default value which falls
+// back to the named environment variable if not found on the command line.
+// It's equivalent to AddInt() followed by SetEnv().
+func (parser *ArgParser) AddIntEnv(name string, value int, envVar string) *Option {
+    bound := parser.AddInt(name, value)
+    bound.opt.envVar = envVar
+    return bound
+}
+
+
+// AddFloatEnv registers a floating-point option with a default value which
+// falls back to the named environment variable if not found on the command
+// line. It's equivalent to AddFloat() followed by SetEnv().
+func (parser *ArgParser) AddFloatEnv(name string, value float64, envVar string) *Option {
+    bound := parser.AddFloat(name, value)
+    bound.opt.envVar = envVar
+    return bound
+}
+
+
+// SetConfig supplies configuration values, e.g. loaded from a file, as a map
+// of option names to string values. The values are consulted after parsing
+// according to the parser's source order and are parsed according to each
+// option's type.
+func (parser *ArgParser) SetConfig(values map[string]string) {
+    parser.config = make(map[string][]string)
+    for key, value := range values {
+        parser.config[key] = []string{value}
+    }
+}
+
+
+// LoadDefaults supplies typed values, e.g. decoded from a JSON config file,
+// as configuration values. If it's called before parsing, the values are
+// merged with any set by SetConfig() and consulted according to the parser's
+// source order. If it's called after parsing, the values are applied
+// directly to the options which weren't found during parsing or supplied by
+// another source. Keys which don't match a registered option name are
+// skipped. Values must match the option's type: booleans for flags, numbers
+// for int and float options, strings for all other types, and slices of
+// these for lists. Returns an error naming the key on the first mismatched
+// value, in which case no values are loaded.
+func (parser *ArgParser) LoadDefaults(values map[string]interface{}) error {
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    converted := make(map[string][]string)
+    for _, key := range keys {
+        opt, ok := parser.options[key]
+        if !ok {
+            continue
+        }
+        err := catch(func() {
+            converted[key] = opt.defaultArgs(values[key])
+        })
+        if err != nil {
+            return fmt.Errorf("%v (from the key '%v')", err, key)
+        }
+    }
+
+    if parser.config == nil {
+        parser.config = make(map[string][]string)
+    }
+    for key, args := range converted {
+        parser.config[key] = args
+    }
+
+    // After parsing, fill the options which no source has supplied a value
+    // for.
+    if !parser.finished {
+        return nil
+    }
+    return catch(func() {
+        for _, key := range keys {
+            if opt, ok := parser.options[key]; ok && opt.source == SourceDefault {
+                parser.resolveSource(opt, SourceConfig)
+            }
+        }
+        for _, bind := range parser.bindings {
+            bind()
+        }
+    })
+}
+
+
+// LoadJSONConfig reads a JSON object from the specified file and supplies
+// its values as configuration values, as LoadDefaults() does, either before
+// or after parsing. Returns an error if the file can't be read or
+// decoded, or on the first mismatched value.
+func (parser *ArgParser) LoadJSONConfig(path string) error {
+    content, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    var values map[string]interface{}
+    if err := json.Unmarshal(content, &values); err != nil {
+        return fmt.Errorf("cannot decode %v: %v", path, err)
+    }
+    return parser.LoadDefaults(values)
+}
+
+
+// Converts a typed default value to the argument strings accepted by
+// trySet(). Exits with an error message if the value's type doesn't match
+// the option's type.
+func (opt *option) defaultArgs(value interface{}) []string {
+    if items, ok := value.([]interface{}); ok && opt.isList {
+        var args []string
+        for _, item := range items {
+            args = append(args, opt.defaultArgs(item)...)
+        }
+        return args
+    }
+    switch v := value.(type) {
+    case bool:
+        if opt.optType == flagOpt {
+            return []string{strconv.FormatBool(v)}
+        }
+    case float64:
+        if opt.optType == floatOpt {
+            return []string{strconv.FormatFloat(v, 'g', -1, 64)}
+        }
+        if opt.optType == intOpt && v == math.Trunc(v) {
+            return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+        }
+    case int:
+        if opt.optType == intOpt || opt.optType == floatOpt {
+            return []string{strconv.Itoa(v)}
+        }
+    case string:
+        if opt.optType != flagOpt && opt.optType != intOpt && opt.optType != floatOpt {
+            return []string{v}
+        }
+    }
+    fail(fmt.Sprintf(
+        "invalid default %v for %v: expected %v value",
+        formatDefault(value),
+        optionLabel(opt.names[0]),
+        opt.typeName(),
+    ))
+    return nil
+}
+
+
+// Formats a default value of arbitrary type for an error message.
+func formatDefault(value interface{}) string {
+    if str, ok := value.(string); ok {
+        return fmt.Sprintf("'%v'", str)
+    }
+    return fmt.Sprintf("%v", value)
+}
+
+
+// SetSourceOrder specifies the order in which the sources of option values
+// are consulted, highest priority first. The default order is SourceCLI,
+// SourceEnv, SourceConfig, SourceDefault. Each option takes its value from
+// the first source in the list which supplies one; sources not in the list
+// are ignored. Command parsers inherit their parent's order unless they set
+// their own.
+func (parser *ArgParser) SetSourceOrder(sources ...Source) {
+    parser.sourceOrder = sources
+}
+
+
+// SourceOf returns the source of the named option's value.
+func (parser *ArgParser) SourceOf(name string) Source {
+    return parser.lookupOption(name).source
+}
+
+
+// Returns the source order for the parser, inherited from its parent if not
+// set.
+func (parser *ArgParser) getSourceOrder() []Source {
+    for p := parser; p != nil; p = p.parent {
+        if p.sourceOrder != nil {
+            return p.sourceOrder
+        }
+    }
+    return []Source{SourceCLI, SourceEnv, SourceConfig, SourceDefault}
+}
+
+
+// Resolve each option's value by consulting its sources in order. Runs once
+// the command line has been parsed.
+func (parser *ArgParser) resolveSources() {
+    order := parser.getSourceOrder()
+    for _, opt := range parser.distinctOptions() {
+        if opt.found {
+            opt.source = SourceCLI
+        }
+        for _, source := range order {
+            if parser.resolveSource(opt, source) {
+                break
+            }
+        }
+    }
+}
+
+
+// Try to take an option's value from the specified source. Returns true if
+// the source supplied a value.
+func (parser *ArgParser) resolveSource(opt *option, source Source) bool {
+    var values []string
+    var origin string
+    switch source {
+    case SourceCLI:
+        return opt.source == SourceCLI
+    case SourceEnv:
+        if opt.envVar == "" || os.Getenv(opt.envVar) == "" {
+            return false
+        }
+        values = []string{os.Getenv(opt.envVar)}
+        origin = "$" + opt.envVar
+    case SourceConfig:
+        found := false
+        for _, name := range opt.names {
+            if values, found = parser.config[name]; found {
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+        origin = "config"
+    case SourceDefault:
+        if opt.source == SourceCLI {
+            opt.resetToDefault()
+        }
+        return true
+    }
+
+    // A value from a lower-priority source replaces any command line value.
+    if opt.source == SourceCLI {
+        opt.resetToDefault()
+    }
+    err := catch(func() {
+        for _, value := range values {
+            opt.trySet(value)
+        }
+    })
+    if err != nil {
+        fail(fmt.Sprintf("%v (from %v)", err, origin))
+    }
+    opt.source = source
+    return true
+}
+
+
+// Discard an option's parsed values, restoring its default.
+func (opt *option) resetToDefault() {
+    opt.clear()
+    if opt.def != nil {
+        opt.values = append(opt.values, *opt.def)
+    }
+    opt.found = false
+    opt.source = SourceDefault
+}
+
+
+// EnableValueTemplating turns on templating for string option values: once
+// all sources have been resolved, each {name} token in a string option's
+// values, including its default, is replaced by the value of the option
+// registered under name. A reference to an unregistered name or to a list
+// option is an error. Substituted values are not themselves expanded.
+// Command parsers inherit this setting from their parent.
+func (parser *ArgParser) EnableValueTemplating() {
+    parser.valueTemplating = true
+}
+
+
+// Returns true if value templating is active for the parser.
+func (parser *ArgParser) getValueTemplating() bool {
+    for p := parser; p != nil; p = p.parent {
+        if p.valueTemplating {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Substitute {name} references in the values of string options. Values are
+// looked up before any substitution so references aren't expanded
+// recursively.
+func (parser *ArgParser) expandTemplates() {
+    resolved := make(map[string]string)
+    for name, opt := range parser.options {
+        if !opt.isList && len(opt.values) > 0 {
+            resolved[name] = opt.formatValue(opt.values[len(opt.values) - 1])
+        }
+    }
+    for _, opt := range parser.distinctOptions() {
+        if opt.optType != strOpt || opt.secret {
+            continue
+        }
+        for i := range opt.values {
+            opt.values[i].strVal = expandTemplate(opt, opt.values[i].strVal, resolved)
+        }
+    }
+}
+
+
+// Substitute {name} references in a single string value.
+func expandTemplate(opt *option, value string, resolved map[string]string) string {
+    var builder strings.Builder
+    for {
+        start := strings.Index(value, "{")
+        if start == -1 {
+            break
+        }
+        end := strings.Index(value[start:], "}")
+        if end == -1 {
+            break
+        }
+        name := value[start + 1 : start + end]
+        replacement, ok := resolved[name]
+        if !ok {
+            failOption(optionLabel(opt.names[0]), fmt.Sprintf(
+                "unresolved reference '{%v}' in the value of %v",
+                name,
+                optionLabel(opt.names[0]),
+            ))
+        }
+        builder.WriteString(value[:start])
+        builder.WriteString(replacement)
+        value = value[start + end + 1:]
+    }
+    builder.WriteString(value)
+    return builder.String()
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: positional arguments.
+// -------------------------------------------------------------------------
+
+
+// HasArgs returns true if the parser has found one or more positional
+// arguments.
+func (parser *ArgParser) HasArgs() bool {
+    return len(parser.arguments) > 0
+}
+
+
+// LenArgs returns the number of positional arguments.
+func (parser *ArgParser) LenArgs() int {
+    return len(parser.arguments)
+}
+
+
+// GetArg returns the positional argument at the specified index.
+func (parser *ArgParser) GetArg(index int) string {
+    return parser.arguments[index]
+}
+
+
+// GetArgs returns the positional arguments as a slice of strings.
+func (parser *ArgParser) GetArgs() []string {
+    return parser.arguments
+}
+
+
+// GetArgsAsInts attempts to parse and return the positional arguments as a
+// slice of integers. The application will exit with an error message if any
+// of the arguments cannot be parsed as an integer.
+func (parser *ArgParser) GetArgsAsInts() []int {
+    ints := make([]int, 0)
+    for _, strArg := range parser.arguments {
+        intArg, err := parseInt(strArg, parser.decimalOnly())
+        if err != nil {
+            parser.exit(err.Error())
+        }
+        ints = append(ints, intArg)
+    }
+    return ints
+}
+
+
+// GetArgsAsFloats attempts to parse and return the positional arguments as a
+// slice of floats. The application will exit with an error message if any
+// of the arguments cannot be parsed as a float.
+func (parser *ArgParser) GetArgsAsFloats() []float64 {
+    floats := make([]float64, 0)
+    for _, strArg := range parser.arguments {
+        floatArg, err := strconv.ParseFloat(strArg, 64)
+        if err != nil {
+            parser.exit(fmt.Sprintf("cannot parse '%v' as a float", strArg))
+        }
+        floats = append(floats, floatArg)
+    }
+    return floats
+}
+
+
+// SplitArgsAt partitions the positional arguments at the first occurrence of
+// the separator, e.g. for the command line 'copy a b to c d' with the
+// separator "to", returning the arguments before and after it. The
+// separator itself is omitted. If the separator is absent, the first slice
+// contains every argument and the second is nil.
+func (parser *ArgParser) SplitArgsAt(sep string) ([]string, []string) {
+    for i, arg := range parser.arguments {
+        if arg == sep {
+            before := append([]string{}, parser.arguments[:i]...)
+            after := append([]string{}, parser.arguments[i + 1:]...)
+            return before, after
+        }
+    }
+    return append([]string{}, parser.arguments...), nil
+}
+
+
+// GetArgsGlobbed returns the positional arguments with each expanded as a
+// glob pattern, e.g. *.go, into the paths it matches, so tools behave
+// consistently on platforms whose shells don't expand patterns. An argument
+// matching no paths is returned as is, unless strict globbing has been
+// turned on using SetGlobStrict(), in which case it's an error, as is a
+// malformed pattern.
+func (parser *ArgParser) GetArgsGlobbed() ([]string, error) {
+    paths := make([]string, 0)
+    for _, arg := range parser.arguments {
+        matches, err := filepath.Glob(arg)
+        if err != nil {
+            return nil, fmt.Errorf("cannot parse '%v' as a glob pattern", arg)
+        }
+        if len(matches) == 0 {
+            if parser.globStrict {
+                return nil, fmt.Errorf("no files match the pattern '%v'", arg)
+            }
+            matches = []string{arg}
+        }
+        paths = append(paths, matches...)
+    }
+    return paths, nil
+}
+
+
+// SetGlobStrict specifies whether GetArgsGlobbed() should treat an argument
+// which matches no paths as an error. The default is false.
+func (parser *ArgParser) SetGlobStrict(strict bool) {
+    parser.globStrict = strict
+}
+
+
+// ArgsIntIter returns an iterator which parses the positional arguments as
+// integers one at a time, on demand. Each call returns the next integer and
+// true; once the arguments are exhausted it returns false. If an argument
+// cannot be parsed the iterator returns false and an error, skipping the
+// argument.
+func (parser *ArgParser) ArgsIntIter() func() (int, bool, error) {
+    next := parser.ArgsStrIter()
+    return func() (int, bool, error) {
+        strArg, ok := next()
+        if !ok {
+            return 0, false, nil
+        }
+        intArg, err := parseInt(strArg, parser.decimalOnly())
+        if err != nil {
+            return 0, false, err
+        }
+        return intArg, true, nil
+    }
+}
+
+
+// ArgsFloatIter returns an iterator which parses the positional arguments
+// as floats one at a time, on demand. Each call returns the next float and
+// true; once the arguments are exhausted it returns false. If an argument
+// cannot be parsed the iterator returns false and an error, skipping the
+// argument.
+func (parser *ArgParser) ArgsFloatIter() func() (float64, bool, error) {
+    next := parser.ArgsStrIter()
+    return func() (float64, bool, error) {
+        strArg, ok := next()
+        if !ok {
+            return 0, false, nil
+        }
+        floatArg, err := strconv.ParseFloat(strArg, 64)
+        if err != nil {
+            return 0, false, fmt.Errorf("cannot parse '%v' as a float", strArg)
+        }
+        return floatArg, true, nil
+    }
+}
+
+
+// ArgsStrIter returns an iterator over the positional arguments. Each call
+// returns the next argument and true; once the arguments are exhausted it
+// returns false.
+func (parser *ArgParser) ArgsStrIter() func() (string, bool) {
+    index := 0
+    return func() (string, bool) {
+        if index >= len(parser.arguments) {
+            return "", false
+        }
+        index += 1
+        return parser.arguments[index - 1], true
+    }
+}
+
+
+// SetArgsValidator registers a function to validate the full list of
+// positional arguments once parsing is complete. If the function returns an
+// error, the application will exit with the error's message.
+func (parser *ArgParser) SetArgsValidator(fn func(args []string) error) {
+    parser.argsValidator = fn
+}
+
+
+// SetArgsMetavar sets the placeholder shown for the parser's positional
+// arguments in generated help text, e.g. "FILE...".
+func (parser *ArgParser) SetArgsMetavar(metavar string) {
+    parser.argsMetavar = metavar
+}
+
+
+// ClearArgs clears the list of positional arguments.
+func (parser *ArgParser) ClearArgs() {
+    parser.arguments = nil
+}
+
+
+// AppendArg appends a string to the list of positional arguments.
+func (parser *ArgParser) AppendArg(arg string) {
+    parser.arguments = append(parser.arguments, arg)
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: commands.
+// -------------------------------------------------------------------------
+
+
+// AddCmd registers a command, its help text, and its associated callback
+// function. The callback function should accept the command's ArgParser
+// instance as its sole agument and should have no return value. Panics if
+// the callback is nil.
+func (parser *ArgParser) AddCmd(name, helptext string, callback func(*ArgParser)) *ArgParser {
+    if callback == nil {
+        panic(fmt.Sprintf("clio: the command '%v' has a nil callback", name))
+    }
+    cmdParser := NewParser(helptext, "")
+    cmdParser.parent = parser
+    cmdParser.names = strings.Split(name, " ")
+    for _, element := range cmdParser.names {
+        parser.commands[element] = cmdParser
+        parser.callbacks[element] = callback
+    }
+    return cmdParser
+}
+
+
+// Validate checks the commands supplied by the parser's command provider
+// under the specified names for programming errors, e.g. in a test: that
+// the provider supplies each command with a parser and a callback. (Commands
+// registered with AddCmd() are checked on registration.) It returns an error
+// describing every problem found, or nil.
+func (parser *ArgParser) Validate(provided ...string) error {
+    problems := make([]string, 0)
+    for _, name := range provided {
+        if problem := parser.providedCmdProblem(name); problem != "" {
+            problems = append(problems, problem)
+        }
+    }
+    if len(problems) == 0 {
+        return nil
+    }
+    return fmt.Errorf("%v", strings.Join(problems, "; "))
+}
+
+
+// Returns a description of the problem with the command supplied by the
+// command provider under the specified name, or an empty string.
+func (parser *ArgParser) providedCmdProblem(name string) string {
+    if parser.cmdProvider == nil {
+        return fmt.Sprintf("no command provider to supply the command '%v'", name)
+    }
+    cmdParser, callback, ok := parser.cmdProvider(name)
+    switch {
+    case !ok:
+        return fmt.Sprintf("the command provider does not supply the command '%v'", name)
+    case cmdParser == nil:
+        return fmt.Sprintf("the provided command '%v' has no parser", name)
+    case callback == nil:
+        return fmt.Sprintf("the provided command '%v' has no callback", name)
+    }
+    return ""
+}
+
+
+// SetCommandProvider registers a function to supply commands which are not
+// registered on the parser, e.g. plugins discovered at runtime. The
+// provider is consulted whenever an argument in command position does not
+// match a registered command; if it returns true, the argument is treated
+// as a command and dispatched to the returned parser and callback. Provided
+// commands are not included in NumCommands() or the generated
+// documentation.
+func (parser *ArgParser) SetCommandProvider(fn func(name string) (*ArgParser, func(*ArgParser), bool)) {
+    parser.cmdProvider = fn
+}
+
+
+// SetCommandCategory specifies the heading under which the named command is
+// listed in generated help text, e.g. "Porcelain" or "Plumbing". Categories
+// are listed in the order they're first used, followed by any uncategorized
+// commands under "Other Commands". Panics if the command isn't registered.
+func (parser *ArgParser) SetCommandCategory(cmdName, category string) {
+    cmdParser, ok := parser.commands[cmdName]
+    if !ok {
+        panic(fmt.Sprintf("clio: '%v' is not a registered command", cmdName))
+    }
+    cmdParser.category = category
+    for _, existing := range parser.categories {
+        if existing == category {
+            return
+        }
+    }
+    parser.categories = append(parser.categories, category)
+}
+
+
+// ParseCommand parses a slice of arguments directly against the named
+// command's sub-parser, then runs the command's callback, as if the command
+// line had been the command name followed by args. This is useful for
+// testing a single command in isolation. Parsing failures, including an
+// unrecognised command name, are returned as a *ParseError.
+func (parser *ArgParser) ParseCommand(name string, args []string) error {
+    cmdParser, callback, ok := parser.lookupCmd(name)
+    if !ok {
+        return &ParseError{Message: parser.unknownCommandMessage(name)}
+    }
+    return catch(func() {
+        parser.dispatch(name, cmdParser, callback, newArgStream(args))
+    })
+}
+
+
+// SetCommandSuggester registers a function supplying suggestions when an
+// unrecognised command name is reported, e.g. by the automatic help command.
+// The function receives the unrecognised name and the names and aliases of
+// the parser's registered commands, and returns candidates to list in the
+// error message as "did you mean: ...". By default, registered names within
+// a small edit distance of the input are suggested. Command parsers inherit
+// the suggester from their parent.
+func (parser *ArgParser) SetCommandSuggester(fn func(input string, known []string) []string) {
+    parser.cmdSuggester = fn
+}
+
+
+// Returns the error message for an unrecognised command name, including any
+// suggestions.
+func (parser *ArgParser) unknownCommandMessage(name string) string {
+    msg := fmt.Sprintf("'%v' is not a recognised command", name)
+    known := make([]string, 0, len(parser.commands))
+    for cmd := range parser.commands {
+        known = append(known, cmd)
+    }
+    sort.Strings(known)
+    suggester := suggestCommands
+    for p := parser; p != nil; p = p.parent {
+        if p.cmdSuggester != nil {
+            suggester = p.cmdSuggester
+            break
+        }
+    }
+    if suggestions := suggester(name, known); len(suggestions) > 0 {
+        msg += "; did you mean: " + strings.Join(suggestions, ", ")
+    }
+    return msg
+}
+
+
+// The default command suggester. Returns the known names within an edit
+// distance of a third of the input's length, rounded up, closest first.
+func suggestCommands(input string, known []string) []string {
+    limit := (len([]rune(input)) + 2) / 3
+    if limit < 1 {
+        limit = 1
+    }
+    suggestions := make([]string, 0)
+    for _, name := range known {
+        if editDistance(input, name) <= limit {
+            suggestions = append(suggestions, name)
+        }
+    }
+    sort.SliceStable(suggestions, func(i, j int) bool {
+        return editDistance(input, suggestions[i]) < editDistance(input, suggestions[j])
+    })
+    return suggestions
+}
+
+
+// Returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    prev := make([]int, len(rb) + 1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        curr := make([]int, len(rb) + 1)
+        curr[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i - 1] == rb[j - 1] {
+                cost = 0
+            }
+            curr[j] = prev[j - 1] + cost
+            if prev[j] + 1 < curr[j] {
+                curr[j] = prev[j] + 1
+            }
+            if curr[j - 1] + 1 < curr[j] {
+                curr[j] = curr[j - 1] + 1
+            }
+        }
+        prev = curr
+    }
+    return prev[len(rb)]
+}
+
+
+// Use registers middleware wrapping the callbacks of commands dispatched by
+// the parser or any of its descendants, e.g. for timing or logging. The
+// middleware receives the next function in the chain and returns a function
+// to call in its place. Middleware runs in registration order, a parent's
+// before its children's, with the command's callback innermost.
+func (parser *ArgParser) Use(mw func(next func(*ArgParser)) func(*ArgParser)) {
+    parser.middleware = append(parser.middleware, middleware(mw))
+}
+
+
+// SetRootAction registers a callback to run on the root parser when no
+// command is found on the command line. Like a command callback, it runs
+// once parsing is complete and receives the root parser as its sole
+// argument.
+func (parser *ArgParser) SetRootAction(fn func(*ArgParser)) {
+    parser.rootAction = fn
+}
+
+
+// HasCmd returns true if the parser has found a command.
+func (parser *ArgParser) HasCmd() bool {
+    return parser.cmdName != ""
+}
+
+
+// GetCmd returns the command name, if the parser has found a command.
+func (parser *ArgParser) GetCmdName() string {
+    return parser.cmdName
+}
+
+
+// GetCmdParser returns the command's parser instance, if a command was found.
+func (parser *ArgParser) GetCmdParser() *ArgParser {
+    return parser.cmdParser
+}
+
+
+// GetParent returns a command parser's parent parser instance.
+func (parser *ArgParser) GetParent() *ArgParser {
+    return parser.parent
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: parsing arguments.
+// -------------------------------------------------------------------------
+
+
+// Parses a stream of string arguments.
+func (parser *ArgParser) parseStream(stream *ArgStream) {
+
+    // Record the time taken, less any time spent running callbacks.
+    start := time.Now()
+    parser.callbackTime = 0
+    defer func() {
+        parser.parseDuration = time.Since(start) - parser.callbackTime
+    }()
+
+    // This parser is responsible for any failure until it dispatches to a
+    // command parser.
+    stream.parser = parser
+
+    // Switch to turn off option parsing if we encounter a double dash.
+    // Everything following the '--' will be treated as a positional
+    // argument.
+    parsing := true
+
+    // Splice in the contents of an arguments file, if any.
+    if parser.argsFileOpt != "" {
+        parser.expandArgsFile(stream)
+    }
+    parser.stream = stream
+    parser.finished = false
+
+    // Loop while we have arguments to process.
+    for stream.HasNext() {
+
+        // Fetch the next argument from the stream.
+        index := stream.index
+        arg := stream.Next()
+
+        // If parsing has been turned off, simply add the argument to the
+        // list of positionals.
+        if !parsing {
+            stream.mark(index, RolePositional, "")
+            parser.arguments = append(parser.arguments, arg)
+            continue
+        }
+
+        // If we encounter a -- argument, turn off option-parsing.
+        if arg == "--" {
+            stream.mark(index, RoleTerminator, "")
+            parsing = false
+            continue
+        }
+
+        // When parsing partially, stop at the first unrecognised option,
+        // leaving it and everything following it unconsumed.
+        if parser.partial && !parser.isKnownOption(arg) {
+            stream.index -= 1
+            break
+        }
+
+        // Is the argument a long-form option or flag?
+        if strings.HasPrefix(arg, "--") {
+            stream.mark(index, RoleOption, strings.SplitN(arg[2:], "=", 2)[0])
+            parser.checkOptionOrder()
+            parser.checkTerminalFlag(arg)
+            parser.parseLongOption(arg[2:], stream)
+            continue
+        }
+
+        // Is the argument a short-form option or flag? If the argument
+        // consists of a single dash or a dash followed by a digit, we treat
+        // it as a positional argument.
+        if strings.HasPrefix(arg, "-") {
+            if arg == "-" || unicode.IsDigit([]rune(arg)[1]) {
+                stream.mark(index, RolePositional, "")
+                parser.arguments = append(parser.arguments, arg)
+            } else {
+                stream.mark(index, RoleOption, strings.SplitN(arg[1:], "=", 2)[0])
+                parser.checkOptionOrder()
+                parser.parseShortOption(arg[1:], stream)
+            }
+            continue
+        }
+
+        // Is the argument a registered command?
+        if cmdParser, callback, ok := parser.lookupCmd(arg); ok {
+            stream.mark(index, RoleCommand, "")
+            parser.dispatch(arg, cmdParser, callback, stream)
+            continue
+        }
+
+        // Is the argument the automatic 'help' command?
+        if keyword := parser.getHelpKeyword(); keyword != "" && arg == keyword {
+            stream.mark(index, RoleCommand, "")
+            if stream.HasNext() {
+                stream.mark(stream.index, RoleCommand, "")
+                name := stream.Next()
+                if cmdParser, _, ok := parser.lookupCmd(name); ok {
+                    if !parser.getAutoExit() || stream.dryRun {
+                        parser.cmdName = name
+                        parser.cmdParser = cmdParser
+                        cmdParser.parent = parser
+                        cmdParser.requestHelp(stream)
+                        break
+                    }
+                    fmt.Fprintln(parser.helpWriter(), cmdParser.fullHelpText())
+                    osExit(0)
+                } else {
+                    fail(parser.unknownCommandMessage(name))
+                }
+            } else {
+                fail("the help command requires an argument")
+            }
+        }
+
+        // If we get here, we have a positional argument. In POSIX mode the
+        // first positional argument turns off option-parsing.
+        stream.mark(index, RolePositional, "")
+        parser.arguments = append(parser.arguments, arg)
+        if parser.posix {
+            parsing = false
+        }
+    }
+
+    // If help or version information was requested there's nothing more to
+    // do - the application will print it. Likewise for a dry run.
+    if parser.helpRequested || parser.versionRequested || stream.dryRun {
+        return
+    }
+
+    // If a command was found, the parse has already been completed before
+    // its callback ran.
+    parser.finish()
+
+    // Run the root action, if any, if no command has been found.
+    if parser.parent == nil && parser.rootAction != nil && !parser.HasCmd() {
+        began := time.Now()
+        parser.rootAction(parser)
+        parser.callbackTime += time.Since(began)
+    }
+}
+
+
+// Complete the parse once the parser has consumed its arguments: resolve
+// option values from their sources, validate the parser's state, and write
+// the final values through any bound pointers. Runs once per parse.
+func (parser *ArgParser) finish() {
+    if parser.finished {
+        return
+    }
+    parser.finished = true
+    parser.resolveSources()
+    if parser.getValueTemplating() {
+        parser.expandTemplates()
+    }
+    parser.validate()
+    for _, bind := range parser.bindings {
+        bind()
+    }
+}
+
+
+// Fail if options are required to precede positional arguments and we've
+// already found a positional argument.
+func (parser *ArgParser) checkOptionOrder() {
+    if parser.optsFirst && len(parser.arguments) > 0 {
+        fail("options must come before arguments")
+    }
+}
+
+
+// Fail if the parser's terminal flag has been found and the argument is a
+// recognised option other than the terminal flag itself. A group of
+// condensed short options is checked one option at a time.
+func (parser *ArgParser) checkTerminalFlag(arg string) {
+    if parser.terminalFlag == "" {
+        return
+    }
+    terminal := parser.options[parser.terminalFlag]
+    if !terminal.found {
+        return
+    }
+    name := strings.SplitN(parser.trimPrefix(strings.TrimLeft(arg, "-")), "=", 2)[0]
+    if parser.options[name] == terminal {
+        return
+    }
+    if parser.isKnownOption(arg) {
+        fail(fmt.Sprintf("no options allowed after %v", optionLabel(parser.terminalFlag)))
+    }
+}
+
+
+// Returns true if the argument is a group of one or more condensed short
+// flags, e.g. -vq.
+func (parser *ArgParser) isFlagGroup(arg string) bool {
+    if len(arg) < 2 || !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
+        return false
+    }
+    for _, char := range arg[1:] {
+        opt, ok := parser.options[string(char)]
+        if !ok || opt.optType != flagOpt {
+            return false
+        }
+    }
+    return true
+}
+
+
+// Find the parser's arguments file option in the stream's remaining
+// arguments and replace it with the file's contents. The file's arguments are
+// moved ahead of the remaining command line arguments so that, for scalar
+// options, values on the command line take precedence. The search stops at a
+// '--' or a command name.
+func (parser *ArgParser) expandArgsFile(stream *ArgStream) {
+    opt := parser.options[parser.argsFileOpt]
+    before := make([]string, 0)
+    after := make([]string, 0)
+    remaining := stream.args[stream.index:]
+
+    for i := 0; i < len(remaining); i++ {
+        arg := remaining[i]
+        if arg == "--" || parser.commands[arg] != nil {
+            after = append(after, remaining[i:]...)
+            break
+        }
+
+        // Match every name of the option, including a short alias at the
+        // end of a group of condensed flags, e.g. -v@ file.
+        path := ""
+        for _, name := range opt.names {
+            label := optionLabel(name)
+            group := ""
+            if len([]rune(name)) == 1 && parser.isFlagGroup(strings.TrimSuffix(arg, name)) {
+                group = strings.TrimSuffix(arg, name)
+                label = arg
+            }
+            if arg == label {
+                if i + 1 == len(remaining) {
+                    fail(fmt.Sprintf("missing argument for %v", optionLabel(name)))
+                }
+                if group != "" {
+                    after = append(after, group)
+                }
+                i += 1
+                path = remaining[i]
+                break
+            } else if strings.HasPrefix(arg, label + "=") {
+                path = arg[len(label) + 1:]
+                break
+            }
+        }
+        if path == "" {
+            after = append(after, arg)
+            continue
+        }
+
+        content, err := os.ReadFile(path)
+        if err != nil {
+            fail(fmt.Sprintf("cannot read the arguments file '%v'", path))
+        }
+        tokens, err := splitArgs(string(content))
+        if err != nil {
+            fail(fmt.Sprintf("cannot parse the arguments file '%v': %v", path, err))
+        }
+        before = append(before, tokens...)
+        opt.found = true
+        opt.setStr(path)
+    }
+
+    args := append([]string{}, stream.args[:stream.index]...)
+    args = append(args, before...)
+    stream.args = append(args, after...)
+    stream.length = len(stream.args)
+}
+
+
+// Split text into arguments on whitespace. Single or double quotes group
+// text containing whitespace into a single argument; lines beginning with a
+// '#' are comments.
+func splitArgs(text string) ([]string, error) {
+    args := make([]string, 0)
+    for _, line := range strings.Split(text, "\n") {
+        if strings.HasPrefix(strings.TrimSpace(line), "#") {
+            continue
+        }
+        var current strings.Builder
+        inArg := false
+        var quote rune
+        for _, char := range line {
+            switch {
+            case quote != 0 && char == quote:
+                quote = 0
+            case quote != 0:
+                current.WriteRune(char)
+            case char == '"' || char == '\'':
+                quote = char
+                inArg = true
+            case unicode.IsSpace(char):
+                if inArg {
+                    args = append(args, current.String())
+                    current.Reset()
+                    inArg = false
+                }
+            default:
+                current.WriteRune(char)
+                inArg = true
+            }
+        }
+        if quote != 0 {
+            return nil, fmt.Errorf("unterminated quote")
+        }
+        if inArg {
+            args = append(args, current.String())
+        }
+    }
+    return args, nil
+}
+
+
+// ParseWindowsCommandLine splits a raw Windows command line into arguments
+// following the rules used by the Microsoft C runtime. Arguments are
+// separated by spaces or tabs; double quotes group text containing
+// whitespace, and a doubled quote inside a quoted section is a literal quote.
+// Backslashes are literal unless they precede a double quote, in which case
+// each pair becomes a single backslash and an odd backslash escapes the
+// quote. Every argument is split using these rules, including the first, so
+// the result can be passed to ParseArgs() with or without the program name
+// removed as appropriate.
+func ParseWindowsCommandLine(line string) []string {
+    args := make([]string, 0)
+    var current strings.Builder
+    inArg := false
+    quoted := false
+    chars := []rune(line)
+    for i := 0; i < len(chars); i++ {
+        switch char := chars[i]; {
+        case char == '\\':
+            count := 0
+            for i < len(chars) && chars[i] == '\\' {
+                count += 1
+                i += 1
+            }
+            if i < len(chars) && chars[i] == '"' {
+                current.WriteString(strings.Repeat("\\", count / 2))
+                if count % 2 == 1 {
+                    current.WriteRune('"')
+                } else {
+                    i -= 1
+                }
+            } else {
+                current.WriteString(strings.Repeat("\\", count))
+                i -= 1
+            }
+            inArg = true
+        case char == '"':
+            if quoted && i + 1 < len(chars) && chars[i + 1] == '"' {
+                current.WriteRune('"')
+                i += 1
+            } else {
+                quoted = !quoted
+            }
+            inArg = true
+        case (char == ' ' || char == '\t') && !quoted:
+            if inArg {
+                args = append(args, current.String())
+                current.Reset()
+                inArg = false
+            }
+        default:
+            current.WriteRune(char)
+            inArg = true
+        }
+    }
+    if inArg {
+        args = append(args, current.String())
+    }
+    return args
+}
+
+
+// Look up a command by name, consulting the command provider, if any, for
+// names not registered on the parser.
+func (parser *ArgParser) lookupCmd(name string) (*ArgParser, cmdCallback, bool) {
+    if cmdParser, ok := parser.commands[name]; ok {
+        return cmdParser, parser.callbacks[name], true
+    }
+    if parser.cmdProvider != nil {
+        if cmdParser, callback, ok := parser.cmdProvider(name); ok {
+            if cmdParser == nil || callback == nil {
+                panic("clio: " + parser.providedCmdProblem(name))
+            }
+            cmdParser.parent = parser
+            if len(cmdParser.names) == 0 {
+                cmdParser.names = []string{name}
+            }
+            return cmdParser, callback, true
+        }
+    }
+    return nil, nil, false
+}
+
+
+// Parse the remaining arguments using the command's parser, then run the
+// command's callback.
+func (parser *ArgParser) dispatch(name string, cmdParser *ArgParser, callback cmdCallback, stream *ArgStream) {
+    parser.cmdName = name
+    parser.cmdParser = cmdParser
+    if parser.posix {
+        cmdParser.posix = true
+    }
+    if parser.singleDashLong {
+        cmdParser.singleDashLong = true
+    }
+    if parser.optsFirst {
+        cmdParser.optsFirst = true
+    }
+    // The command parser consumes the remaining arguments and validates
+    // its state, e.g. checking its required options, so the callback below
+    // never runs with an invalid command line.
+    cmdParser.parseStream(stream)
+    parser.callbackTime += cmdParser.callbackTime
+    if cmdParser.helpRequested || cmdParser.versionRequested || stream.dryRun {
+        return
+    }
+
+    // This parser and any ancestors parsing the same stream have consumed
+    // all their arguments, so complete their parses before the callback
+    // runs. The callback sees their final values and never runs with an
+    // invalid command line.
+    for p := parser; p != nil && p.stream == stream; p = p.parent {
+        stream.parser = p
+        p.finish()
+    }
+    stream.parser = parser
+
+    // Wrap the callback in the middleware registered on this parser and its
+    // ancestors, the root's first registered middleware outermost.
+    chain := make([]middleware, 0)
+    for p := parser; p != nil; p = p.parent {
+        chain = append(append([]middleware{}, p.middleware...), chain...)
+    }
+    wrapped := (func(*ArgParser))(callback)
+    for i := len(chain) - 1; i >= 0; i-- {
+        wrapped = chain[i](wrapped)
+    }
+    began := time.Now()
+    wrapped(cmdParser)
+    parser.callbackTime += time.Since(began)
+}
+
+
+// EnableArgsFileOption registers a string option, e.g. "args-file", whose
+// value is the path of a file containing additional arguments. The file's
+// contents are split on whitespace - quotes group text containing
+// whitespace and lines beginning with a '#' are comments - and parsed ahead
+// of the remaining command line arguments, so values on the command line
+// take precedence over scalar values from the file while list values are
+// merged.
+func (parser *ArgParser) EnableArgsFileOption(name string) {
+    parser.AddStr(name, "")
+    parser.argsFileOpt = strings.Split(name, " ")[0]
+}
+
+
+// SetStripPrefix specifies a prefix to strip from long-form option names
+// before they are looked up, e.g. with the prefix "myapp-" the argument
+// --myapp-verbose is treated as --verbose. Arguments without the prefix are
+// looked up as normal, as are prefixed names which are themselves
+// registered.
+func (parser *ArgParser) SetStripPrefix(p string) {
+    parser.stripPrefix = p
+}
+
+
+// SetUnknownOptionHandler registers a function to handle unrecognised
+// options on this parser, overriding the default behaviour of exiting with
+// an error message. The handler receives the option as it appeared on the
+// command line, e.g. "--foo", "-f", or "--foo=bar", along with the
+// argument stream, from which it may consume any values belonging to the
+// option. If the handler returns an error, parsing fails with its message;
+// otherwise parsing continues. The handler is not inherited by command
+// parsers.
+func (parser *ArgParser) SetUnknownOptionHandler(fn func(name string, stream *ArgStream) error) {
+    parser.unknownHandler = fn
+}
+
+
+// EnablePassthrough turns on passthrough mode: unrecognised options on this
+// parser are collected rather than causing an exit, and can be retrieved
+// using GetUnknown(), e.g. for forwarding to a downstream tool. As the
+// parser can't know whether an unrecognised option takes a value, only the
+// option itself is collected; any following value is parsed as normal. This
+// mode replaces any handler registered with SetUnknownOptionHandler() and is
+// not inherited by command parsers.
+func (parser *ArgParser) EnablePassthrough() {
+    parser.SetUnknownOptionHandler(func(name string, stream *ArgStream) error {
+        parser.unknown = append(parser.unknown, name)
+        return nil
+    })
+}
+
+
+// GetUnknown returns the unrecognised options collected in passthrough mode,
+// in the order they appeared on the command line.
+func (parser *ArgParser) GetUnknown() []string {
+    return append([]string{}, parser.unknown...)
+}
+
+
+// Check the parser's state once all arguments have been consumed. Exit with
+// an error message if the state is invalid.
+func (parser *ArgParser) validate() {
+    for _, opt := range parser.distinctOptions() {
+        if opt.required && opt.source == SourceDefault {
+            failOption(
+                optionLabel(opt.names[0]),
+                fmt.Sprintf("the %v option is required", optionLabel(opt.names[0])),
+            )
+        }
+    }
+
+    for _, opt := range parser.distinctOptions() {
+        if len(opt.scope) > 0 && opt.found && !parser.dispatched(opt.scope) {
+            fail(fmt.Sprintf(
+                "%v can only be used with the '%v' command",
+                optionLabel(opt.names[0]),
+                strings.Join(opt.scope, "' or '"),
+            ))
+        }
+    }
+
+    // A requirement is satisfied by a value from any source but is only
+    // triggered by an option found on the command line.
+    for _, dep := range parser.dependencies {
+        if parser.lookupOption(dep.target).isSet() {
+            continue
+        }
+        if dep.unless && !parser.lookupOption(dep.cond).isSet() {
+            fail(fmt.Sprintf(
+                "%v is required unless %v is set",
+                optionLabel(dep.target),
+                optionLabel(dep.cond),
+            ))
+        }
+        if !dep.unless && parser.lookupOption(dep.cond).found {
+            fail(fmt.Sprintf(
+                "%v is required when %v is set",
+                optionLabel(dep.target),
+                optionLabel(dep.cond),
+            ))
+        }
+    }
+
+    for _, reqs := range parser.oneOfs {
+        count := 0
+        descs := make([]string, 0, len(reqs))
+        for _, req := range reqs {
+            if req.holds(parser) {
+                count += 1
+            }
+            descs = append(descs, req.desc)
+        }
+        if count != 1 {
+            fail(fmt.Sprintf(
+                "exactly one of %v is required",
+                strings.Join(descs, " or "),
+            ))
+        }
+    }
+
+    for _, opt := range parser.distinctOptions() {
+        if opt.prompt != "" && !opt.isSet() {
+            parser.confirm(opt)
+        }
+    }
+
+    if parser.argsValidator != nil {
+        if err := parser.argsValidator(parser.arguments); err != nil {
+            fail(err.Error())
+        }
+    }
+}
+
+
+// Ask the user to confirm the action guarded by a confirmation flag. Fails
+// on a negative answer or if stdin is not a terminal.
+func (parser *ArgParser) confirm(opt *option) {
+    if !isInteractive() {
+        fail(fmt.Sprintf(
+            "%v is required to confirm this action when not running interactively",
+            optionLabel(opt.names[0]),
+        ))
+    }
+    fmt.Fprintf(parser.errWriter(), "%v [y/N] ", opt.prompt)
+    answer, err := readLine(parser.getStdinTimeout())
+    if err != nil {
+        fail(fmt.Sprintf("cannot read confirmation: %v", err))
+    }
+    if ok, _ := parseBool(strings.TrimSpace(answer)); !ok {
+        fail("aborted")
+    }
+}
+
+
+// SetStdinTimeout sets the maximum time the parser will wait when reading
+// input from stdin, e.g. the answer to a confirmation prompt. A read which
+// times out aborts with an error rather than blocking indefinitely. The
+// abandoned read continues in the background until a line arrives or stdin
+// is closed; the line is kept for the next read. Command parsers inherit
+// their parent's timeout unless they set their own.
+func (parser *ArgParser) SetStdinTimeout(d time.Duration) {
+    parser.stdinTimeout = d
+}
+
+
+// Returns the stdin timeout for the parser, inherited from its parent if not
+// set.
+func (parser *ArgParser) getStdinTimeout() time.Duration {
+    for p := parser; p != nil; p = p.parent {
+        if p.stdinTimeout != 0 {
+            return p.stdinTimeout
+        }
+    }
+    return 0
+}
+
+
+// Returns true if the parser has dispatched one of the named commands.
+func (parser *ArgParser) dispatched(names []string) bool {
+    for _, name := range names {
+        if cmdParser, ok := parser.commands[name]; ok {
+            if parser.cmdParser == cmdParser {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+
+// ParseArgs parses a slice of string arguments.
+func (parser *ArgParser) ParseArgs(args []string) {
+    if _, taken := parser.options["debug-args"]; !taken && parser.getDebugArgs() && requestsDebugArgs(args) {
+        fmt.Fprint(parser.outWriter(), parser.debugArgsText(args))
+        osExit(0)
+    }
+    stream := newArgStream(args)
+    err := catch(func() {
+        parser.parseStream(stream)
+    })
+    if err != nil {
+        failed := stream.parser
+        failed.exitWithError(err.(*ParseError), failed.usageFooterText())
+    }
+}
+
+
+// EnableDebugArgs activates an automatic --debug-args flag for the parser and
+// its commands. If the flag appears anywhere on the command line before a
+// '--', ParseArgs() prints how each argument was interpreted, along with any
+// parsing error, and exits, without validating the command line or running
+// callbacks. This helps users diagnose why an option wasn't recognised. The
+// values of secret options are printed as ****.
+func (parser *ArgParser) EnableDebugArgs() {
+    parser.debugArgs = true
+}
+
+
+// Returns true if the --debug-args flag is active for the parser.
+func (parser *ArgParser) getDebugArgs() bool {
+    for p := parser; p != nil; p = p.parent {
+        if p.debugArgs {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Returns true if the arguments contain --debug-args before any '--'.
+func requestsDebugArgs(args []string) bool {
+    for _, arg := range args {
+        if arg == "--" {
+            return false
+        }
+        if arg == "--debug-args" {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Parse the arguments without validating them or running callbacks and
+// describe the role of each argument, followed by any parsing error.
+func (parser *ArgParser) debugArgsText(args []string) string {
+    stream := newArgStream(args)
+    stream.dryRun = true
+    err := catch(func() {
+        parser.parseStream(stream)
+    })
+
+    // Mask the values of secret options, including the value part of
+    // arguments of the form --name=value.
+    roles := parser.ArgRoles()
+    for i := range roles {
+        if !stream.secrets[i] {
+            continue
+        }
+        if roles[i].Role == RoleOption {
+            roles[i].Arg = strings.SplitN(roles[i].Arg, "=", 2)[0] + "=****"
+        } else {
+            roles[i].Arg = "****"
+        }
+    }
+
+    width := 0
+    for _, role := range roles {
+        if len(role.Arg) > width {
+            width = len(role.Arg)
+        }
+    }
+    var builder strings.Builder
+    builder.WriteString("Arguments:\n")
+    for i, role := range roles {
+        desc := role.Role.String()
+        switch {
+        case role.Role == RoleOption && role.Option != "":
+            desc += " " + role.Option
+        case role.Role == RoleValue && role.Option != "":
+            desc += " for " + role.Option
+        }
+        fmt.Fprintf(&builder, "  %2d  %-*s  %s\n", i, width, role.Arg, desc)
+    }
+    if err != nil {
+        fmt.Fprintf(&builder, "Error: %v.\n", err)
+    }
+    return builder.String()
+}
+
+
+// ParsePartial parses a slice of string arguments, stopping at the first
+// unrecognised option instead of treating it as an error. It returns the
+// unconsumed arguments, beginning with the unrecognised option, so they can
+// be passed on to another parser. Any other parsing failure is returned as
+// a *ParseError. Note that partial parsing applies to the parser itself; if
+// a command is found, its arguments are parsed by its sub-parser as normal.
+func (parser *ArgParser) ParsePartial(args []string) (leftover []string, err error) {
+    stream := newArgStream(args)
+    parser.partial = true
+    defer func() {
+        parser.partial = false
+    }()
+    err = catch(func() {
+        parser.parseStream(stream)
+    })
+    return stream.args[stream.index:], err
+}
+
+
+// Returns true if the argument is a registered or automatic option name, or
+// is not an option at all. Used to find the stopping point for partial
+// parsing.
+func (parser *ArgParser) isKnownOption(arg string) bool {
+    if !strings.HasPrefix(arg, "-") || arg == "-" {
+        return true
+    }
+
+    if strings.HasPrefix(arg, "--") {
+        name := strings.SplitN(parser.trimPrefix(arg[2:]), "=", 2)[0]
+        if _, ok := parser.parseLookup(name); ok {
+            return true
+        }
+        if _, _, ok := parser.lookupIndexed(name); ok {
+            return true
+        }
+        if _, ok := parser.valueAliases[name]; ok {
+            return true
+        }
+        if parser.lookupNegated(name) != nil {
+            return true
+        }
+        if (name == "help" && parser.hasHelp()) || (name == "debug-args" && parser.getDebugArgs()) {
+            return true
+        }
+        return name == "version" && parser.version != ""
+    }
+
+    name := arg[1:]
+    if unicode.IsDigit([]rune(name)[0]) {
+        return true
+    }
+    if strings.Contains(name, "=") {
+        _, ok := parser.parseLookup(strings.SplitN(name, "=", 2)[0])
+        return ok
+    }
+    if parser.singleDashLong {
+        if _, ok := parser.parseLookup(name); ok {
+            return true
+        }
+    }
+    for _, char := range name {
+        if _, ok := parser.parseLookup(string(char)); ok {
+            continue
+        }
+        if _, ok := parser.valueAliases[string(char)]; !ok {
+            return false
+        }
+    }
+    return true
+}
+
+
+// Parse parses the application's command line arguments.
+func (parser *ArgParser) Parse() {
+    parser.ParseArgs(os.Args[1:])
+}
+
+
+// Run parses the application's command line arguments as Parse() does. As
+// command callbacks and the root action run during parsing, Run() only adds
+// one further step: if the parser has registered commands but none was
+// found and no root action has been registered, it exits with an error
+// message followed by the usage text generated by BuildHelp(). This makes a
+// command mandatory for command-based tools without a default behaviour.
+func (parser *ArgParser) Run() {
+    parser.Parse()
+    if parser.missingCommand() {
+        parser.exitWithError(
+            &ParseError{Message: "a command is required"},
+            parser.BuildHelp(),
+        )
+    }
+}
+
+
+// Returns true if a command is required but was not found.
+func (parser *ArgParser) missingCommand() bool {
+    if parser.helpRequested || parser.versionRequested {
+        return false
+    }
+    return len(parser.commands) > 0 && !parser.HasCmd() && parser.rootAction == nil
+}
+
+
+// Parse a long-form option, i.e. an option beginning with a double dash.
+func (parser *ArgParser) parseLongOption(arg string, stream *ArgStream) {
+
+    // Strip the parser's name prefix, if any.
+    arg = parser.trimPrefix(arg)
+
+    // Do we have an option of the form --name=value?
+    if strings.Contains(arg, "=") {
+        parser.parseEqualsOption("--", arg, stream)
+        return
+    }
+
+    // Is the argument a registered option name?
+    if opt, ok := parser.parseLookup(arg); ok {
+        opt.found = true
+
+        // If the option is a flag, record its presence.
+        if opt.optType == flagOpt {
+            opt.setPresent()
+            return
+        }
+
+        // Not a flag, so parse the following option value or values.
+        parser.parseValues(opt, "--" + arg, stream)
+        return
+    }
+
+    // Is the argument an indexed option of the form --name.N.field?
+    if opt, key, ok := parser.lookupIndexed(arg); ok {
+        opt.found = true
+        if !stream.HasNextValue() {
+            fail(fmt.Sprintf("missing argument for --%v", arg))
+        }
+        stream.mark(stream.index, RoleValue, opt.names[0])
+        opt.values = append(opt.values, optionValue{strVal: stream.Next(), key: key})
+        return
+    }
+
+    // Is the argument a value alias standing in for another option?
+    if alias, ok := parser.valueAliases[arg]; ok {
+        parser.applyValueAlias(alias)
+        return
+    }
+
+    // Is the argument the negated form of a negatable flag, --no-name?
+    if opt := parser.lookupNegated(arg); opt != nil {
+        opt.found = true
+        opt.setFlag(false)
+        return
+    }
+
+    // Is the argument the automatic --help flag?
+    if arg == "help" && parser.hasHelp() {
+        if !parser.getAutoExit() || stream.dryRun {
+            parser.requestHelp(stream)
+            return
+        }
+        fmt.Fprintln(parser.helpWriter(), parser.fullHelpText())
+        osExit(0)
+    }
+
+    // Is the argument the automatic --debug-args flag? It has been handled
+    // by ParseArgs() before parsing began.
+    if arg == "debug-args" && parser.getDebugArgs() {
+        return
+    }
+
+    // Is the argument the automatic --version flag?
+    if arg == "version" && parser.version != "" {
+        if !parser.getAutoExit() || stream.dryRun {
+            parser.requestVersion(stream)
+            return
+        }
+        fmt.Fprintln(parser.outWriter(), parser.versionText())
+        osExit(0)
+    }
+
+    // The argument is not a registered or automatic option name.
+    parser.unknownOption("--" + arg, stream)
+}
+
+
+// Look up a negatable flag from a long-form name of the form no-name. Only
+// long names can be negated. Returns nil if there is no such flag.
+func (parser *ArgParser) lookupNegated(arg string) *option {
+    if !strings.HasPrefix(arg, "no-") || len([]rune(arg)) < 5 {
+        return nil
+    }
+    if opt, ok := parser.options[arg[3:]]; ok && opt.negatable {
+        return opt
+    }
+    return nil
+}
+
+
+// The largest index accepted by an indexed option. Indices are stored
+// densely so an unbounded index could exhaust memory.
+const maxIndex = 1000
+
+
+// Look up an option by name while parsing. A command parser also recognises
+// the scoped flags of its ancestors, so a scoped flag may follow the name of
+// the command it belongs to.
+func (parser *ArgParser) parseLookup(name string) (*option, bool) {
+    if opt, ok := parser.options[name]; ok {
+        return opt, true
+    }
+    for p := parser.parent; p != nil; p = p.parent {
+        if opt, ok := p.options[name]; ok && len(opt.scope) > 0 {
+            return opt, true
+        }
+    }
+    return nil, false
+}
+
+
+// Look up an indexed option from a long-form name of the form name.N.field.
+// Returns the option and the N.field key. Fails if the index exceeds the
+// maximum.
+func (parser *ArgParser) lookupIndexed(arg string) (*option, string, bool) {
+    split := strings.SplitN(arg, ".", 3)
+    if len(split) != 3 || split[2] == "" {
+        return nil, "", false
+    }
+    opt, ok := parser.options[split[0]]
+    if !ok || opt.optType != indexedOpt {
+        return nil, "", false
+    }
+    index, err := strconv.Atoi(split[1])
+    if err != nil || index < 0 {
+        return nil, "", false
+    }
+    if index > maxIndex {
+        failOption(optionLabel(split[0]), fmt.Sprintf(
+            "the index %v for %v exceeds the maximum of %v",
+            split[1],
+            optionLabel(split[0]),
+            maxIndex,
+        ))
+    }
+    return opt, fmt.Sprintf("%v.%v", index, split[2]), true
+}
+
+
+// Strip the parser's name prefix from a long-form option, unless the
+// unstripped name is itself registered.
+func (parser *ArgParser) trimPrefix(arg string) string {
+    if parser.stripPrefix == "" || !strings.HasPrefix(arg, parser.stripPrefix) {
+        return arg
+    }
+    if _, ok := parser.options[strings.SplitN(arg, "=", 2)[0]]; ok {
+        return arg
+    }
+    return strings.TrimPrefix(arg, parser.stripPrefix)
+}
+
+
+// Parse a short-form option, i.e. an option beginning with a single dash.
+func (parser *ArgParser) parseShortOption(arg string, stream *ArgStream) {
+
+    // Do we have an option of the form -n=value?
+    if strings.Contains(arg, "=") {
+        parser.checkTerminalFlag("-" + arg)
+        parser.parseEqualsOption("-", arg, stream)
+        return
+    }
+
+    // In single-dash long option mode, a registered name matching the whole
+    // argument takes precedence over a cluster of short options.
+    if parser.singleDashLong && len([]rune(arg)) > 1 {
+        if opt, ok := parser.parseLookup(arg); ok {
+            parser.checkTerminalFlag("-" + arg)
+            opt.found = true
+            if opt.optType == flagOpt {
+                opt.setPresent()
+            } else {
+                parser.parseValues(opt, "the -" + arg + " option", stream)
+            }
+            return
+        }
+    }
+
+    // We handle each character individually to support condensed options:
+    //    -abc foo bar
+    // is equivalent to:
+    //    -a foo -b bar -c
+    for _, char := range arg {
+        name := string(char)
+        parser.checkTerminalFlag("-" + name)
+
+        // Do we have the name of a registered option?
+        if opt, ok := parser.parseLookup(name); ok {
+            opt.found = true
+
+            // If the option is a flag, record its presence.
+            if opt.optType == flagOpt {
+                opt.setPresent()
+                continue
+            }
+
+            // Not a flag, so parse the following option value or values.
+            parser.parseValues(opt, "the -" + name + " option", stream)
+
+        // A value alias standing in for another option.
+        } else if alias, ok := parser.valueAliases[name]; ok {
+            parser.applyValueAlias(alias)
+
+        // Not a registered option.
+        } else {
+            parser.unknownOption("-" + name, stream)
+        }
+    }
+}
+
+
+// Handle an unrecognised option. The token is the option as it appeared on
+// the command line, including its dashes and, for options of the form
+// --name=value, its value. If the parser has an unknown-option handler, the
+// decision is delegated to it; otherwise parsing fails.
+func (parser *ArgParser) unknownOption(token string, stream *ArgStream) {
+    if parser.unknownHandler != nil {
+        start := stream.index
+        if err := parser.unknownHandler(token, stream); err != nil {
+            fail(err.Error())
+        }
+        name := strings.TrimLeft(strings.SplitN(token, "=", 2)[0], "-")
+        stream.markValues(start, name)
+        return
+    }
+    name := strings.SplitN(token, "=", 2)[0]
+    failOption(name, fmt.Sprintf("%v is not a recognised option", name))
+}
+
+
+// Parse the value or values following an option which requires an argument.
+// The label identifies the option in error messages.
+func (parser *ArgParser) parseValues(opt *option, label string, stream *ArgStream) {
+    start := stream.index
+    defer stream.markValues(start, opt.names[0])
+    if opt.secret {
+        defer stream.markSecret(start)
+    }
+
+    // A capturing option takes every remaining argument as a value,
+    // whatever its form.
+    if opt.capture {
+        if !stream.HasNext() {
+            failOption(optionLabel(opt.names[0]), fmt.Sprintf("missing argument for %v", label))
+        }
+        for stream.HasNext() {
+            opt.trySet(stream.Next())
+        }
+        return
+    }
+
+    // Check for a following option value.
+    if !opt.hasNextValue(stream) {
+        failOption(optionLabel(opt.names[0]), fmt.Sprintf("missing argument for %v", label))
+    }
+
+    // Try to parse the argument as a value of the appropriate type.
+    opt.trySet(stream.Next())
+
+    // If the option is a greedy list, keep trying to parse values until we
+    // run out of arguments. By default a greedy list stops at a '--'
+    // terminator, leaving it to turn off option-parsing; a list set to
+    // consume the terminator swallows it and everything that follows. A
+    // list with a sentinel stops at the sentinel, discarding it.
+    if opt.greedy {
+        for opt.hasNextValue(stream) {
+            if opt.sentinel != "" && stream.Peek() == opt.sentinel {
+                stream.Next()
+                return
+            }
+            parser.warnIfAmbiguous(opt, label, stream.Peek())
+            opt.trySet(stream.Next())
+        }
+        if opt.consumeTerminator && stream.HasNext() && stream.Peek() == "--" {
+            stream.Next()
+            for stream.HasNext() {
+                opt.trySet(stream.Next())
+            }
+        }
+    }
+}
+
+
+// Print a warning if a greedy list is about to consume an argument which
+// matches a command name, assuming ambiguity warnings are turned on.
+func (parser *ArgParser) warnIfAmbiguous(opt *option, label string, arg string) {
+    var w io.Writer
+    for p := parser; p != nil && w == nil; p = p.parent {
+        w = p.ambiguityOut
+    }
+    if w == nil {
+        return
+    }
+    keyword := parser.getHelpKeyword()
+    if _, ok := parser.commands[arg]; ok || (keyword != "" && arg == keyword && len(parser.commands) > 0) {
+        fmt.Fprintf(
+            w,
+            "Warning: greedy option %v consumed '%v', which is also a command name.\n",
+            strings.TrimPrefix(strings.TrimSuffix(label, " option"), "the "),
+            arg,
+        )
+    }
+}
+
+
+// Parse an option of the form --name=value or -n=value. A boolean flag in
+// this form accepts any of the values recognised by parseBool.
+func (parser *ArgParser) parseEqualsOption(prefix string, arg string, stream *ArgStream) {
+    split := strings.SplitN(arg, "=", 2)
+    name := split[0]
+    value := split[1]
+
+    // Is the name an indexed option of the form --name.N.field?
+    if prefix == "--" {
+        if opt, key, ok := parser.lookupIndexed(name); ok {
+            opt.found = true
+            if value == "" {
+                failOption("--" + name, fmt.Sprintf("missing argument for the --%s option", name))
+            }
+            opt.values = append(opt.values, optionValue{strVal: value, key: key})
+            return
+        }
+    }
+
     // Do we have the name of a registered option?
-    opt, ok := parser.options[name]
+    opt, ok := parser.parseLookup(name)
     if !ok {
-        exit(fmt.Sprintf("%s%s is not a recognised option", prefix, name))
+        parser.unknownOption(prefix + arg, stream)
+        return
+    }
+    opt.found = true
+    if opt.secret {
+        stream.markSecret(stream.index - 1)
+    }
+
+    // Check that a value has been supplied.
+    if value == "" {
+        failOption(prefix + name, fmt.Sprintf("missing argument for the %s%s option", prefix, name))
+    }
+
+    // A boolean list counts its occurrences; an explicit value sets the
+    // count directly, e.g. --verbose=3.
+    if opt.optType == flagOpt && opt.isList {
+        count, err := strconv.Atoi(value)
+        if err != nil || count < 0 {
+            failOption(
+                prefix + name,
+                fmt.Sprintf("cannot parse '%v' as a count for the %s%s option", value, prefix, name),
+            )
+        }
+        opt.clear()
+        for i := 0; i < count; i++ {
+            opt.setFlag(true)
+        }
+        return
+    }
+
+    // Try to parse the argument as a value of the appropriate type.
+    opt.trySet(value)
+
+    // A capturing option also takes every remaining argument.
+    if opt.capture {
+        for stream.HasNext() {
+            opt.trySet(stream.Next())
+        }
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// ArgParser: utilities.
+// -------------------------------------------------------------------------
+
+
+// Returns the parser's registered options with aliases deduplicated, sorted
+// by primary name.
+func (parser *ArgParser) distinctOptions() []*option {
+    seen := make(map[*option]bool)
+    opts := make([]*option, 0)
+    for _, opt := range parser.options {
+        if !seen[opt] {
+            seen[opt] = true
+            opts = append(opts, opt)
+        }
+    }
+    sort.Slice(opts, func(i, j int) bool {
+        return opts[i].names[0] < opts[j].names[0]
+    })
+    return opts
+}
+
+
+// Returns the parser's registered commands with aliases deduplicated, sorted
+// by primary name.
+func (parser *ArgParser) distinctCommands() []*ArgParser {
+    seen := make(map[*ArgParser]bool)
+    cmds := make([]*ArgParser, 0)
+    for _, cmdParser := range parser.commands {
+        if !seen[cmdParser] {
+            seen[cmdParser] = true
+            cmds = append(cmds, cmdParser)
+        }
+    }
+    sort.Slice(cmds, func(i, j int) bool {
+        return cmds[i].names[0] < cmds[j].names[0]
+    })
+    return cmds
+}
+
+
+// Returns the application's name as invoked.
+func progName() string {
+    return filepath.Base(os.Args[0])
+}
+
+
+// Returns the full invocation path of the parser, e.g. 'app cmd subcmd'.
+func (parser *ArgParser) commandPath() string {
+    if parser.parent == nil {
+        return progName()
+    }
+    return parser.parent.commandPath() + " " + parser.names[0]
+}
+
+
+// Returns true if the list contains the string.
+func contains(list []string, str string) bool {
+    for _, element := range list {
+        if element == str {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Returns true if the list contains the string, ignoring case.
+func containsFold(list []string, str string) bool {
+    for _, element := range list {
+        if strings.EqualFold(element, str) {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Returns an option name formatted with the appropriate dash prefix.
+func optionLabel(name string) string {
+    if len([]rune(name)) == 1 {
+        return "-" + name
+    }
+    return "--" + name
+}
+
+
+// NumOptions returns the number of distinct options registered on the
+// parser. An option registered with multiple aliases is counted once.
+func (parser *ArgParser) NumOptions() int {
+    return len(parser.distinctOptions())
+}
+
+
+// NumCommands returns the number of distinct commands registered on the
+// parser. A command registered with multiple aliases is counted once.
+func (parser *ArgParser) NumCommands() int {
+    return len(parser.distinctCommands())
+}
+
+
+// Help prints the parser's help text, then exits.
+func (parser *ArgParser) Help() {
+    fmt.Fprintln(parser.helpWriter(), parser.fullHelpText())
+    osExit(0)
+}
+
+
+// GetHelpText returns the parser's help text, followed by its epilog if one
+// has been set. Unlike Help() it doesn't print anything or exit. Help text
+// generated by BuildHelp() is not included.
+func (parser *ArgParser) GetHelpText() string {
+    return withEpilog(parser.helptext, parser.epilog)
+}
+
+
+// SetEpilog sets text to print after the parser's help text, e.g. usage
+// examples or links to further documentation. The epilog is separated from
+// the help text by a blank line and, like the help text, is printed as is.
+// It also appears at the end of the parser's section in the Markdown
+// documentation. Setting an epilog doesn't activate the automatic --help
+// flag by itself.
+func (parser *ArgParser) SetEpilog(text string) {
+    parser.epilog = strings.TrimSpace(text)
+}
+
+
+// Returns true if the automatic --help flag is active: the parser has help
+// text of its own, or at least one of its options has a description for the
+// generated help text.
+func (parser *ArgParser) hasHelp() bool {
+    if parser.helptext != "" {
+        return true
+    }
+    for _, opt := range parser.options {
+        if opt.desc != "" {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Returns the parser's help text, or the generated help text if it has none,
+// followed by its epilog, if any.
+func (parser *ArgParser) fullHelpText() string {
+    if parser.helptext == "" {
+        return withEpilog(parser.BuildHelp(), parser.epilog)
+    }
+    return withEpilog(parser.helptext, parser.epilog)
+}
+
+
+// Returns help text followed by an epilog, separated by a blank line.
+func withEpilog(helptext, epilog string) string {
+    if epilog == "" {
+        return helptext
+    }
+    if helptext == "" {
+        return epilog
+    }
+    return helptext + "\n\n" + epilog
+}
+
+
+// GetVersion returns the parser's version string. Build metadata supplied via
+// SetVersionInfo() is not included.
+func (parser *ArgParser) GetVersion() string {
+    return parser.version
+}
+
+
+// SetVersionInfo sets the application's version number along with optional
+// build metadata, typically injected via ldflags. If either the commit or
+// the date is non-empty, the --version flag prints a multi-line block
+// containing the program name and version followed by the commit and build
+// date. Empty strings are omitted.
+func (parser *ArgParser) SetVersionInfo(version, commit, date string) {
+    parser.version = strings.TrimSpace(version)
+    parser.commit = strings.TrimSpace(commit)
+    parser.buildDate = strings.TrimSpace(date)
+}
+
+
+// Returns the text printed by the --version flag.
+func (parser *ArgParser) versionText() string {
+    if parser.commit == "" && parser.buildDate == "" {
+        return parser.version
+    }
+    lines := []string{fmt.Sprintf("%v %v", progName(), parser.version)}
+    if parser.commit != "" {
+        lines = append(lines, "commit " + parser.commit)
+    }
+    if parser.buildDate != "" {
+        lines = append(lines, "built " + parser.buildDate)
+    }
+    return strings.Join(lines, "\n")
+}
+
+
+// SetHelpDestination specifies the writer to which help text is printed. The
+// default is the parser's standard output. Command parsers inherit their
+// parent's destination unless they set their own.
+func (parser *ArgParser) SetHelpDestination(w io.Writer) {
+    parser.helpOut = w
+}
+
+
+// SetOut specifies the writer to which the parser prints standard output,
+// e.g. help text and version information. The default is stdout. Command
+// parsers inherit their parent's writer unless they set their own.
+func (parser *ArgParser) SetOut(w io.Writer) {
+    parser.out = w
+}
+
+
+// SetErr specifies the writer to which the parser prints error output, e.g.
+// error messages and confirmation prompts. The default is stderr. Command
+// parsers inherit their parent's writer unless they set their own.
+func (parser *ArgParser) SetErr(w io.Writer) {
+    parser.errOut = w
+}
+
+
+// SetUsageFooter sets the line printed after the error message when parsing
+// fails, e.g. "Run 'myprog --help' for more information." If the parser has
+// help text the default footer is a line of this form naming the failing
+// command; otherwise there is no default. An empty string turns the footer
+// off. Command parsers inherit their parent's footer unless they set their
+// own.
+func (parser *ArgParser) SetUsageFooter(footer string) {
+    parser.usageFooter = &footer
+}
+
+
+// Returns the footer to print after a parsing error reported by this parser.
+func (parser *ArgParser) usageFooterText() string {
+    for p := parser; p != nil; p = p.parent {
+        if p.usageFooter != nil {
+            return *p.usageFooter
+        }
+    }
+    if parser.hasHelp() {
+        return fmt.Sprintf("Run '%v --help' for more information.", parser.commandPath())
+    }
+    return ""
+}
+
+
+// SetHelpKeyword replaces the name of the automatic help command, 'help', e.g.
+// with "?" for tools where 'help' means something else. An empty string
+// turns the help command off. The automatic --help flag is unaffected.
+// Command parsers inherit their parent's keyword unless they set their own.
+func (parser *ArgParser) SetHelpKeyword(keyword string) {
+    parser.helpKeyword = &keyword
+}
+
+
+// Returns the name of the automatic help command, inherited from its parent
+// if not set.
+func (parser *ArgParser) getHelpKeyword() string {
+    for p := parser; p != nil; p = p.parent {
+        if p.helpKeyword != nil {
+            return *p.helpKeyword
+        }
+    }
+    return "help"
+}
+
+
+// SetAutoExit specifies whether the automatic --help and --version flags and
+// help command print their output and exit, the default. If false, parsing
+// stops when one is found - without validating the command line or running
+// callbacks - and HelpRequested() or VersionRequested() returns true,
+// leaving the application to print the output. Where help for a command is
+// requested, the command's parser is available via GetCmdParser(). Command
+// parsers inherit their parent's setting unless they set their own.
+func (parser *ArgParser) SetAutoExit(autoExit bool) {
+    parser.autoExit = &autoExit
+}
+
+
+// Returns true if the automatic help and version handlers should exit,
+// inherited from the parser's parent if not set.
+func (parser *ArgParser) getAutoExit() bool {
+    for p := parser; p != nil; p = p.parent {
+        if p.autoExit != nil {
+            return *p.autoExit
+        }
+    }
+    return true
+}
+
+
+// LastParseDuration returns the time taken by the parser's most recent parse,
+// for attributing startup cost to argument parsing. Time spent running
+// command callbacks and the root action is excluded; time spent parsing a
+// command's arguments is included in its parent's duration as well as being
+// available from the command parser itself. Returns zero if the parser has
+// not parsed any arguments.
+func (parser *ArgParser) LastParseDuration() time.Duration {
+    return parser.parseDuration
+}
+
+
+// ArgRoles returns the role each argument played in the parser's most recent
+// parse, in input order, e.g. for editor integrations that highlight
+// arguments. Each argument is classified as an option, an option value, a
+// positional argument, a command (including the help command and its
+// argument), or the '--' terminator. Arguments the parser never examined -
+// those following a help or version request, or left unconsumed by
+// ParsePartial() - are classified as RoleUnparsed. The arguments of a
+// command are included. If an arguments file was expanded, the roles refer
+// to the arguments after expansion. Returns nil if the parser has not parsed
+// any arguments.
+func (parser *ArgParser) ArgRoles() []ArgRole {
+    if parser.stream == nil {
+        return nil
     }
-    opt.found = true
+    roles := make([]ArgRole, 0, parser.stream.length)
+    for i, arg := range parser.stream.args[:parser.stream.length] {
+        role := ArgRole{}
+        if i < len(parser.stream.roles) {
+            role = parser.stream.roles[i]
+        }
+        role.Arg = arg
+        roles = append(roles, role)
+    }
+    return roles
+}
+
 
-    // Boolean flags should never contain an equals sign.
-    if opt.optType == flagOpt {
-        exit(fmt.Sprintf("invalid format for boolean flag %s%s", prefix, name))
+// HelpRequested returns true if help was requested with auto-exit turned
+// off, either for this parser or for one of its commands.
+func (parser *ArgParser) HelpRequested() bool {
+    return parser.helpRequested
+}
+
+
+// VersionRequested returns true if version information was requested with
+// auto-exit turned off, either for this parser or for one of its commands.
+func (parser *ArgParser) VersionRequested() bool {
+    return parser.versionRequested
+}
+
+
+// Record a request for help on the parser and its ancestors, skipping the
+// stream's remaining arguments.
+func (parser *ArgParser) requestHelp(stream *ArgStream) {
+    for p := parser; p != nil; p = p.parent {
+        p.helpRequested = true
     }
+    stream.index = stream.length
+}
 
-    // Check that a value has been supplied.
-    if value == "" {
-        exit(fmt.Sprintf("missing argument for the %s%s option", prefix, name))
+
+// Record a request for version information on the parser and its
+// ancestors, skipping the stream's remaining arguments.
+func (parser *ArgParser) requestVersion(stream *ArgStream) {
+    for p := parser; p != nil; p = p.parent {
+        p.versionRequested = true
     }
+    stream.index = stream.length
+}
 
-    // Try to parse the argument as a value of the appropriate type.
-    opt.trySet(value)
+
+// Returns the writer to which the parser should print help text.
+func (parser *ArgParser) helpWriter() io.Writer {
+    for p := parser; p != nil; p = p.parent {
+        if p.helpOut != nil {
+            return p.helpOut
+        }
+    }
+    return parser.outWriter()
 }
 
 
-// -------------------------------------------------------------------------
-// ArgParser: utilities.
-// -------------------------------------------------------------------------
+// Returns the writer to which the parser should print standard output.
+func (parser *ArgParser) outWriter() io.Writer {
+    for p := parser; p != nil; p = p.parent {
+        if p.out != nil {
+            return p.out
+        }
+    }
+    return os.Stdout
+}
 
 
-// Help prints the parser's help text, then exits.
-func (parser *ArgParser) Help() {
-    fmt.Println(parser.helptext)
-    os.Exit(0)
+// Returns the writer to which the parser should print error output.
+func (parser *ArgParser) errWriter() io.Writer {
+    for p := parser; p != nil; p = p.parent {
+        if p.errOut != nil {
+            return p.errOut
+        }
+    }
+    return os.Stderr
+}
+
+
+// A ParserSnapshot is a point-in-time copy of a parser's state, returned by
+// Dump().
+type ParserSnapshot struct {
+
+    // The parser's distinct options, sorted by primary name.
+    Options []OptionSnapshot
+
+    // The positional arguments found while parsing.
+    Arguments []string
+
+    // The name of the command found while parsing, if any, and a snapshot of
+    // its parser.
+    Command string
+    CommandSnapshot *ParserSnapshot
+}
+
+
+// An OptionSnapshot is a point-in-time copy of an option's state, returned
+// as part of a ParserSnapshot.
+type OptionSnapshot struct {
+
+    // The option's primary name and any additional aliases.
+    Name string
+    Aliases []string
+
+    // The option's type name as returned by TypeOf(), and whether it's a
+    // list.
+    Type string
+    IsList bool
+
+    // True if the option was found while parsing.
+    Found bool
+
+    // The option's current values, formatted as strings. A scalar option has
+    // a single value, its default if the option was not found.
+    Values []string
+}
+
+
+// Dump returns a snapshot of the parser's state - its options and their
+// values, its positional arguments, and the command found, if any - for
+// programmatic inspection, e.g. by tests. Unlike String(), the snapshot is a
+// typed structure.
+func (parser *ArgParser) Dump() ParserSnapshot {
+    snapshot := ParserSnapshot{
+        Options: make([]OptionSnapshot, 0),
+        Arguments: append([]string{}, parser.arguments...),
+        Command: parser.cmdName,
+    }
+    for _, opt := range parser.distinctOptions() {
+        values := make([]string, 0, len(opt.values))
+        for _, value := range opt.values {
+            values = append(values, opt.displayValue(value))
+        }
+        if !opt.isList && len(values) > 0 {
+            values = values[len(values) - 1:]
+        }
+        snapshot.Options = append(snapshot.Options, OptionSnapshot{
+            Name: opt.names[0],
+            Aliases: append([]string{}, opt.names[1:]...),
+            Type: opt.typeName(),
+            IsList: opt.isList,
+            Found: opt.found,
+            Values: values,
+        })
+    }
+    if parser.cmdParser != nil {
+        cmdSnapshot := parser.cmdParser.Dump()
+        snapshot.CommandSnapshot = &cmdSnapshot
+    }
+    return snapshot
+}
+
+
+// ConfigTable returns a two-column table of the parser's options and their
+// current values, one row per distinct option, e.g. for a --show-config
+// flag. A string option shows the value returned by GetStr(), including any
+// fallback or computed default. List values are separated by commas. Rows wider than the terminal,
+// as given by the COLUMNS environment variable (default 80), have their
+// values truncated with an ellipsis.
+func (parser *ArgParser) ConfigTable() string {
+    width := 80
+    if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+        width = columns
+    }
+
+    opts := parser.distinctOptions()
+    nameWidth := 0
+    for _, opt := range opts {
+        if n := utf8.RuneCountInString(optionLabel(opt.names[0])); n > nameWidth {
+            nameWidth = n
+        }
+    }
+
+    lines := make([]string, 0, len(opts))
+    for _, opt := range opts {
+        values := make([]string, 0, len(opt.values))
+        for _, value := range opt.values {
+            values = append(values, opt.displayValue(value))
+        }
+        if !opt.isList && len(values) > 0 {
+            values = values[len(values) - 1:]
+        }
+        if opt.optType == strOpt && !opt.isList {
+            values = []string{opt.displayValue(optionValue{strVal: opt.getStr()})}
+        }
+        valstr := []rune(strings.Join(values, ", "))
+        if room := width - nameWidth - 2; len(valstr) > room {
+            if room > 3 {
+                valstr = append(valstr[:room - 3], []rune("...")...)
+            } else {
+                valstr = nil
+            }
+        }
+        lines = append(lines, fmt.Sprintf(
+            "%-*v  %v",
+            nameWidth,
+            optionLabel(opt.names[0]),
+            string(valstr),
+        ))
+    }
+    return strings.Join(lines, "\n")
+}
+
+
+// WriteTo writes the parser's string representation to w, terminated by
+// exactly one newline. It returns the number of bytes written and any error
+// encountered.
+func (parser *ArgParser) WriteTo(w io.Writer) (int64, error) {
+    n, err := io.WriteString(w, parser.String() + "\n")
+    return int64(n), err
 }
 
 
@@ -924,15 +5607,29 @@ func (parser *ArgParser) String() string {
             var valstr string
             opt := parser.options[name]
 
-            switch opt.optType {
-            case flagOpt:
+            switch {
+            case opt.secret:
+                masked := make([]string, 0, len(opt.values))
+                for _, optVal := range opt.values {
+                    masked = append(masked, opt.displayValue(optVal))
+                }
+                valstr = fmt.Sprintf("%v", masked)
+            case opt.optType == flagOpt:
                 valstr = fmt.Sprintf("%v", opt.getFlagList())
-            case strOpt:
+            case opt.optType == strOpt:
                 valstr = fmt.Sprintf("%v", opt.getStrList())
-            case intOpt:
+            case opt.optType == intOpt:
                 valstr = fmt.Sprintf("%v", opt.getIntList())
-            case floatOpt:
+            case opt.optType == floatOpt:
                 valstr = fmt.Sprintf("%v", opt.getFloatList())
+            case opt.optType == ipOpt:
+                valstr = fmt.Sprintf("%v", opt.getIPList())
+            default:
+                formatted := make([]string, 0, len(opt.values))
+                for _, optVal := range opt.values {
+                    formatted = append(formatted, opt.formatValue(optVal))
+                }
+                valstr = fmt.Sprintf("%v", formatted)
             }
 
             lines = append(lines, fmt.Sprintf("  %v: %v", name, valstr))
@@ -959,3 +5656,564 @@ func (parser *ArgParser) String() string {
 
     return strings.Join(lines, "\n")
 }
+
+
+// -------------------------------------------------------------------------
+// ArgParser: generating documentation.
+// -------------------------------------------------------------------------
+
+
+// BuildHelp generates help text from the parser's registered options and
+// commands: a usage line, ending with any placeholder for the positional
+// arguments set with SetArgsMetavar(), followed by aligned sections listing
+// flags, options which take values, and commands. Each option is listed with
+// its aliases, any description set with Desc(), and, for options taking
+// values, its type, any choices, and its default value. Commands are grouped
+// under the headings set with SetCommandCategory(), if any. The automatic
+// --help flag prints this text if the parser was created without help text
+// of its own.
+func (parser *ArgParser) BuildHelp() string {
+    type entry struct {
+        label string
+        desc string
+    }
+    flags := make([]entry, 0)
+    values := make([]entry, 0)
+    for _, opt := range parser.distinctOptions() {
+        labels := make([]string, 0, len(opt.names))
+        for _, name := range opt.names {
+            if len([]rune(name)) == 1 {
+                labels = append(labels, optionLabel(name))
+            }
+        }
+        for _, name := range opt.names {
+            if len([]rune(name)) > 1 && opt.negatable {
+                labels = append(labels, "--[no-]" + name)
+            } else if len([]rune(name)) > 1 {
+                labels = append(labels, optionLabel(name))
+            }
+        }
+        label := strings.Join(labels, ", ")
+        if opt.optType == flagOpt {
+            flags = append(flags, entry{label, opt.desc})
+            continue
+        }
+        if opt.metavar != "" {
+            label += " " + opt.metavar
+        } else {
+            label += " <" + opt.typeName() + ">"
+        }
+        desc := opt.desc
+        if len(opt.choices) > 0 {
+            desc = strings.TrimSpace(fmt.Sprintf(
+                "%v (choose from: %v)",
+                desc,
+                strings.Join(opt.choices, ", "),
+            ))
+        }
+        if opt.def != nil {
+            if def := opt.displayValue(*opt.def); def != "" {
+                desc = strings.TrimSpace(fmt.Sprintf("%v (default: %v)", desc, def))
+            }
+        }
+        values = append(values, entry{label, desc})
+    }
+    aliases := make([]string, 0, len(parser.valueAliases))
+    for alias := range parser.valueAliases {
+        aliases = append(aliases, alias)
+    }
+    sort.Strings(aliases)
+    for _, alias := range aliases {
+        target := parser.valueAliases[alias]
+        desc := fmt.Sprintf("Same as %v %v.", optionLabel(target.target), target.value)
+        flags = append(flags, entry{optionLabel(alias), desc})
+    }
+    if _, ok := parser.options["help"]; !ok && parser.hasHelp() {
+        flags = append(flags, entry{"--help", "Print this help text and exit."})
+    }
+    if _, ok := parser.options["version"]; !ok && parser.version != "" {
+        flags = append(flags, entry{"--version", "Print the version number and exit."})
+    }
+    if _, ok := parser.options["debug-args"]; !ok && parser.getDebugArgs() {
+        flags = append(flags, entry{"--debug-args", "Print how the arguments were parsed and exit."})
+    }
+
+    cmds := make([]entry, 0)
+    categorized := make(map[string][]entry)
+    for _, cmdParser := range parser.distinctCommands() {
+        e := entry{strings.Join(cmdParser.names, ", "), ""}
+        if cmdParser.category != "" {
+            categorized[cmdParser.category] = append(categorized[cmdParser.category], e)
+        } else {
+            cmds = append(cmds, e)
+        }
+    }
+
+    usage := "Usage: " + parser.commandPath()
+    if len(flags) > 0 || len(values) > 0 {
+        usage += " [options]"
+    }
+    if len(cmds) > 0 || len(categorized) > 0 {
+        usage += " [command]"
+    }
+    if parser.argsMetavar != "" {
+        usage += " " + parser.argsMetavar
+    }
+    lines := []string{usage}
+
+    type section struct {
+        title string
+        entries []entry
+    }
+    sections := []section{
+        {"Flags:", flags},
+        {"Options:", values},
+    }
+    if len(categorized) == 0 {
+        sections = append(sections, section{"Commands:", cmds})
+    } else {
+        for _, category := range parser.categories {
+            sections = append(sections, section{category + ":", categorized[category]})
+        }
+        sections = append(sections, section{"Other Commands:", cmds})
+    }
+
+    width := 0
+    for _, section := range sections {
+        for _, e := range section.entries {
+            if n := utf8.RuneCountInString(e.label); n > width {
+                width = n
+            }
+        }
+    }
+    for _, section := range sections {
+        if len(section.entries) == 0 {
+            continue
+        }
+        lines = append(lines, "", section.title)
+        for _, e := range section.entries {
+            line := fmt.Sprintf("  %-*s  %s", width, e.label, e.desc)
+            lines = append(lines, strings.TrimRight(line, " "))
+        }
+    }
+    return strings.Join(lines, "\n")
+}
+
+
+// HelpMarkdown renders the parser's help text, positional arguments,
+// options, and commands as a Markdown document. Options are listed with
+// their descriptions and metavars. Each command in the command tree receives
+// its own section, nested under its parent's list of commands.
+func (parser *ArgParser) HelpMarkdown() string {
+    lines := make([]string, 0)
+    parser.writeMarkdown(&lines, 1)
+    return strings.Join(lines, "\n") + "\n"
+}
+
+
+// Appends the Markdown documentation for the parser and its commands to
+// lines, starting at the specified heading level.
+func (parser *ArgParser) writeMarkdown(lines *[]string, level int) {
+    heading := mdHeading(level)
+    *lines = append(*lines, fmt.Sprintf("%v %v", heading, parser.commandPath()), "")
+
+    if parser.helptext != "" {
+        *lines = append(*lines, "```", parser.helptext, "```", "")
+    }
+
+    if len(parser.names) > 1 {
+        aliases := make([]string, 0, len(parser.names) - 1)
+        for _, alias := range parser.names[1:] {
+            aliases = append(aliases, mdCode(alias))
+        }
+        *lines = append(*lines, "Aliases: " + strings.Join(aliases, ", "), "")
+    }
+
+    if parser.argsMetavar != "" {
+        *lines = append(*lines, "Arguments: " + mdCode(parser.argsMetavar), "")
+    }
+
+    subheading := mdHeading(level + 1)
+
+    if opts := parser.distinctOptions(); len(opts) > 0 {
+        *lines = append(*lines, subheading + " Options", "")
+        *lines = append(*lines, "| Option | Type | Default | Description |")
+        *lines = append(*lines, "| ------ | ---- | ------- | ----------- |")
+        for _, opt := range opts {
+            labels := make([]string, 0, len(opt.names))
+            for _, name := range opt.names {
+                label := optionLabel(name)
+                if opt.metavar != "" {
+                    label += " " + opt.metavar
+                }
+                labels = append(labels, mdCode(label))
+            }
+            typename := opt.displayType()
+            if len(opt.choices) > 0 {
+                typename += " (choose from " + strings.Join(opt.choices, ", ") + ")"
+            }
+            def := ""
+            if opt.def != nil {
+                def = mdCode(opt.displayValue(*opt.def))
+            }
+            *lines = append(*lines, fmt.Sprintf(
+                "| %v | %v | %v | %v |",
+                strings.Join(labels, ", "),
+                typename,
+                def,
+                strings.ReplaceAll(opt.desc, "|", "\\|"),
+            ))
+        }
+        *lines = append(*lines, "")
+    }
+
+    cmds := parser.distinctCommands()
+    if len(cmds) > 0 {
+        *lines = append(*lines, subheading + " Commands", "")
+        for _, cmdParser := range cmds {
+            *lines = append(*lines, "* " + mdCode(cmdParser.names[0]))
+        }
+        *lines = append(*lines, "")
+    }
+
+    if parser.epilog != "" {
+        *lines = append(*lines, parser.epilog, "")
+    }
+
+    for _, cmdParser := range cmds {
+        cmdParser.writeMarkdown(lines, level + 2)
+    }
+}
+
+
+// Returns the Markdown heading prefix for the specified level.
+func mdHeading(level int) string {
+    if level > 6 {
+        level = 6
+    }
+    return strings.Repeat("#", level)
+}
+
+
+// Formats a string as a Markdown code span, escaping table delimiters.
+func mdCode(str string) string {
+    if str == "" {
+        return "`\"\"`"
+    }
+    str = strings.ReplaceAll(str, "|", "\\|")
+    if strings.Contains(str, "`") {
+        return "`` " + str + " ``"
+    }
+    return "`" + str + "`"
+}
+
+
+// GenerateManPage returns a man page for the parser in roff format, suitable
+// for writing to a file like prog.1 at build time. The page includes the
+// parser's help text, its options with their descriptions, types, and
+// defaults, and its commands. Each command in the command tree receives its
+// own section listing its help text, options, and sub-commands.
+func (parser *ArgParser) GenerateManPage(progName, section string) string {
+    lines := make([]string, 0)
+    lines = append(lines, fmt.Sprintf(
+        ".TH \"%v\" \"%v\"",
+        roffEscape(strings.ToUpper(progName)),
+        roffEscape(section),
+    ))
+
+    lines = append(lines, ".SH NAME", roffEscape(progName))
+    lines = append(lines, ".SH SYNOPSIS", parser.manSynopsis(progName))
+
+    if parser.helptext != "" {
+        lines = append(lines, ".SH DESCRIPTION")
+        lines = append(lines, roffBlock(parser.helptext)...)
+    }
+
+    if opts := parser.distinctOptions(); len(opts) > 0 {
+        lines = append(lines, ".SH OPTIONS")
+        lines = append(lines, manOptions(opts)...)
+    }
+
+    if cmds := parser.distinctCommands(); len(cmds) > 0 {
+        lines = append(lines, ".SH COMMANDS")
+        lines = append(lines, manCommands(cmds)...)
+        for _, cmdParser := range cmds {
+            cmdParser.writeManSection(&lines, progName + " " + cmdParser.names[0])
+        }
+    }
+
+    return strings.Join(lines, "\n") + "\n"
+}
+
+
+// Appends a man page section for a command and, recursively, for each of
+// its sub-commands. The path is the command's full name, e.g. "app build".
+func (parser *ArgParser) writeManSection(lines *[]string, path string) {
+    *lines = append(*lines, fmt.Sprintf(".SH \"%v\"", roffEscape(strings.ToUpper(path))))
+    *lines = append(*lines, parser.manSynopsis(path))
+    if parser.helptext != "" {
+        *lines = append(*lines, ".PP")
+        *lines = append(*lines, roffBlock(parser.helptext)...)
+    }
+    if opts := parser.distinctOptions(); len(opts) > 0 {
+        *lines = append(*lines, ".SS OPTIONS")
+        *lines = append(*lines, manOptions(opts)...)
+    }
+    cmds := parser.distinctCommands()
+    if len(cmds) > 0 {
+        *lines = append(*lines, ".SS COMMANDS")
+        *lines = append(*lines, manCommands(cmds)...)
+    }
+    for _, cmdParser := range cmds {
+        cmdParser.writeManSection(lines, path + " " + cmdParser.names[0])
+    }
+}
+
+
+// Returns the roff synopsis line for a parser invoked as path.
+func (parser *ArgParser) manSynopsis(path string) string {
+    synopsis := "\\fB" + roffEscape(path) + "\\fR"
+    if len(parser.options) > 0 {
+        synopsis += " [options]"
+    }
+    if len(parser.commands) > 0 {
+        synopsis += " [command]"
+    }
+    if parser.argsMetavar != "" {
+        synopsis += " \\fI" + roffEscape(parser.argsMetavar) + "\\fR"
+    }
+    return synopsis
+}
+
+
+// Returns roff entries for a list of options, each with its names, metavar,
+// description, type, and default value.
+func manOptions(opts []*option) []string {
+    lines := make([]string, 0)
+    for _, opt := range opts {
+        labels := make([]string, 0, len(opt.names))
+        for _, name := range opt.names {
+            label := "\\fB" + roffEscape(optionLabel(name)) + "\\fR"
+            if opt.metavar != "" {
+                label += " \\fI" + roffEscape(opt.metavar) + "\\fR"
+            }
+            labels = append(labels, label)
+        }
+        lines = append(lines, ".TP", strings.Join(labels, ", "))
+        if opt.desc != "" {
+            lines = append(lines, roffEscape(opt.desc), ".br")
+        }
+        typename := opt.displayType()
+        if len(opt.choices) > 0 {
+            typename += " (choose from " + strings.Join(opt.choices, ", ") + ")"
+        }
+        if opt.def != nil {
+            typename += fmt.Sprintf(" (default: %v)", opt.displayValue(*opt.def))
+        }
+        lines = append(lines, roffEscape(typename))
+    }
+    return lines
+}
+
+
+// Returns roff entries for a list of commands, each with its names and help
+// text.
+func manCommands(cmds []*ArgParser) []string {
+    lines := make([]string, 0)
+    for _, cmdParser := range cmds {
+        labels := make([]string, 0, len(cmdParser.names))
+        for _, name := range cmdParser.names {
+            labels = append(labels, "\\fB" + roffEscape(name) + "\\fR")
+        }
+        lines = append(lines, ".TP", strings.Join(labels, ", "))
+        if cmdParser.helptext != "" {
+            lines = append(lines, roffBlock(cmdParser.helptext)...)
+        }
+    }
+    return lines
+}
+
+
+// GenerateDOT returns a Graphviz DOT description of the parser's command
+// tree, with one node per distinct command labelled with the command's name
+// and its number of options.
+func (parser *ArgParser) GenerateDOT(progName string) string {
+    lines := []string{fmt.Sprintf("digraph %v {", dotQuote(progName))}
+    parser.writeDOT(&lines, progName, progName)
+    lines = append(lines, "}")
+    return strings.Join(lines, "\n") + "\n"
+}
+
+
+// Appends DOT nodes and edges for the parser and its commands to lines. The
+// node's id is the command's full path, its label the command's name.
+func (parser *ArgParser) writeDOT(lines *[]string, id, name string) {
+    count := parser.NumOptions()
+    label := fmt.Sprintf("%v\\n%v options", name, count)
+    if count == 1 {
+        label = fmt.Sprintf("%v\\n1 option", name)
+    }
+    *lines = append(*lines, fmt.Sprintf("    %v [label=%v];", dotQuote(id), dotQuote(label)))
+    for _, cmdParser := range parser.distinctCommands() {
+        cmdID := id + " " + cmdParser.names[0]
+        *lines = append(*lines, fmt.Sprintf("    %v -> %v;", dotQuote(id), dotQuote(cmdID)))
+        cmdParser.writeDOT(lines, cmdID, cmdParser.names[0])
+    }
+}
+
+
+// Formats a string as a quoted DOT identifier. Backslashes are left as is
+// so escape sequences like \n in labels are preserved.
+func dotQuote(str string) string {
+    return "\"" + strings.ReplaceAll(str, "\"", "\\\"") + "\""
+}
+
+
+// Escapes a string for use as roff text: backslashes and hyphens are
+// escaped, and a leading control character is neutralised.
+func roffEscape(str string) string {
+    str = strings.ReplaceAll(str, "\\", "\\e")
+    str = strings.ReplaceAll(str, "-", "\\-")
+    if strings.HasPrefix(str, ".") || strings.HasPrefix(str, "'") {
+        str = "\\&" + str
+    }
+    return str
+}
+
+
+// Returns a block of text as escaped roff lines with filling turned off, so
+// the text's own line breaks and indentation are preserved.
+func roffBlock(text string) []string {
+    lines := []string{".nf"}
+    for _, line := range strings.Split(text, "\n") {
+        lines = append(lines, roffEscape(line))
+    }
+    return append(lines, ".fi")
+}
+
+
+// JSON description of a parser for consumption by completion frameworks.
+type completionSpec struct {
+    Name string `json:"name"`
+    Aliases []string `json:"aliases"`
+    Args string `json:"args"`
+    Options []completionOption `json:"options"`
+    Commands []completionSpec `json:"commands"`
+}
+
+
+// JSON description of a single option.
+type completionOption struct {
+    Name string `json:"name"`
+    Aliases []string `json:"aliases"`
+    Type string `json:"type"`
+    List bool `json:"list"`
+    TakesValue bool `json:"takesValue"`
+    Metavar string `json:"metavar"`
+    Description string `json:"description"`
+}
+
+
+// CompletionSpec returns a shell-agnostic JSON description of the command
+// tree for use by external completion tooling. Each node lists the
+// command's name and aliases, the placeholder for its positional arguments,
+// its options (with their names, aliases, types, descriptions, and, for
+// options which take a value, their metavars), and its sub-commands. The
+// root node is named after the application.
+func (parser *ArgParser) CompletionSpec() []byte {
+    data, _ := json.Marshal(parser.completionSpec())
+    return data
+}
+
+
+// Builds the completion description for the parser and its commands.
+func (parser *ArgParser) completionSpec() completionSpec {
+    spec := completionSpec{
+        Name: progName(),
+        Aliases: []string{},
+        Args: parser.argsMetavar,
+        Options: []completionOption{},
+        Commands: []completionSpec{},
+    }
+    if parser.parent != nil {
+        spec.Name = parser.names[0]
+        spec.Aliases = parser.names[1:]
+    }
+    for _, opt := range parser.distinctOptions() {
+        option := completionOption{
+            Name: opt.names[0],
+            Aliases: opt.names[1:],
+            Type: opt.typeName(),
+            List: opt.isList,
+            TakesValue: opt.optType != flagOpt,
+            Description: opt.desc,
+        }
+        if option.TakesValue {
+            option.Metavar = opt.metavar
+            if option.Metavar == "" {
+                option.Metavar = "<" + opt.typeName() + ">"
+            }
+        }
+        spec.Options = append(spec.Options, option)
+    }
+    for _, cmdParser := range parser.distinctCommands() {
+        spec.Commands = append(spec.Commands, cmdParser.completionSpec())
+    }
+    return spec
+}
+
+
+// -------------------------------------------------------------------------
+// Testing.
+// -------------------------------------------------------------------------
+
+
+// Signals a replaced exit inside Capture().
+type captureExit int
+
+
+// Capture is a testing aid. It creates a new parser, passes it to fn to
+// register options and commands, then parses args, capturing anything the
+// parser prints along with the status code it exits with. The status code
+// is zero if the parser doesn't exit. Output printed directly by callbacks,
+// rather than through the parser, isn't captured. Capture replaces the
+// package's exit function while it runs so it isn't safe for concurrent
+// use.
+func Capture(fn func(*ArgParser), args []string) (stdout, stderr string, exitCode int) {
+    var outBuf, errBuf strings.Builder
+    parser := NewParser("", "")
+    fn(parser)
+    parser.SetOut(&outBuf)
+    parser.SetErr(&errBuf)
+
+    exitCode = catchExit(func() {
+        parser.ParseArgs(args)
+    })
+    if exitCode < 0 {
+        exitCode = 0
+    }
+    return outBuf.String(), errBuf.String(), exitCode
+}
+
+
+// Runs fn with the package's exit function replaced, returning the status
+// code fn exits with, or -1 if it returns normally. Used by Capture() and
+// the tests.
+func catchExit(fn func()) (code int) {
+    previous := osExit
+    osExit = func(code int) {
+        panic(captureExit(code))
+    }
+    defer func() {
+        osExit = previous
+        if r := recover(); r != nil {
+            exited, ok := r.(captureExit)
+            if !ok {
+                panic(r)
+            }
+            code = int(exited)
+        }
+    }()
+    fn()
+    return -1
+}
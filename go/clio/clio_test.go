@@ -3,6 +3,15 @@ package clio
 
 import (
     "testing"
+    "os"
+    "fmt"
+    "strings"
+    "net"
+    "encoding/json"
+    "io"
+    "bufio"
+    "time"
+    "math"
 )
 
 
@@ -795,3 +804,4669 @@ func TestCommandWithOptions(t *testing.T) {
         t.Fail()
     }
 }
+
+
+// -------------------------------------------------------------------------
+// POSIX mode.
+// -------------------------------------------------------------------------
+
+
+func TestPOSIXModeOff(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool")
+    parser.ParseArgs([]string{"foo", "--bool"})
+    if parser.GetFlag("bool") != true {
+        t.Fail()
+    }
+    if parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestPOSIXModeOn(t *testing.T) {
+    parser := NewParser("", "")
+    parser.POSIXMode()
+    parser.AddFlag("bool")
+    parser.ParseArgs([]string{"foo", "--bool"})
+    if parser.GetFlag("bool") != false {
+        t.Fail()
+    }
+    if parser.LenArgs() != 2 {
+        t.Fail()
+    }
+    if parser.GetArg(1) != "--bool" {
+        t.Fail()
+    }
+}
+
+
+func TestPOSIXModeCommand(t *testing.T) {
+    parser := NewParser("", "")
+    parser.POSIXMode()
+    cmdParser := parser.AddCmd("cmd", "helptext", callback)
+    cmdParser.AddFlag("bool")
+    parser.ParseArgs([]string{"cmd", "--bool", "foo", "--bool"})
+    if parser.HasCmd() != true {
+        t.Fail()
+    }
+    if cmdParser.LenList("bool") != 2 {
+        t.Fail()
+    }
+    if cmdParser.LenArgs() != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Scoped flags.
+// -------------------------------------------------------------------------
+
+
+func TestScopedFlagAbsent(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddScopedFlag("bool", "cmd")
+    parser.AddCmd("cmd", "helptext", callback)
+    parser.ParseArgs([]string{})
+    if parser.GetFlag("bool") != false {
+        t.Fail()
+    }
+}
+
+
+func TestScopedFlagWithCommand(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddScopedFlag("bool b", "cmd")
+    parser.AddCmd("cmd alias", "helptext", callback)
+    parser.ParseArgs([]string{"-b", "alias"})
+    if parser.GetFlag("bool") != true {
+        t.Fail()
+    }
+    if parser.GetCmdName() != "alias" {
+        t.Fail()
+    }
+}
+
+
+func TestScopedFlagWrongCommand(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddScopedFlag("bool b", "cmd")
+    parser.AddCmd("cmd", "helptext", callback)
+    parser.AddCmd("other", "helptext", func(p *ArgParser) {
+        ran = true
+    })
+    err := tryParse(parser, []string{"-b", "other"})
+    if err == nil || ran {
+        t.Fail()
+    }
+}
+
+
+func TestScopedFlagAfterCommand(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddScopedFlag("release r", "build")
+    parser.AddCmd("build", "helptext", callback)
+    parser.ParseArgs([]string{"build", "--release"})
+    if !parser.GetFlag("release") {
+        t.Fail()
+    }
+    parser = NewParser("", "")
+    parser.AddScopedFlag("release r", "build")
+    parser.AddCmd("build", "helptext", callback)
+    parser.ParseArgs([]string{"build", "-r"})
+    if !parser.GetFlag("release") {
+        t.Fail()
+    }
+}
+
+
+func TestScopedFlagAfterWrongCommand(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddScopedFlag("release", "build")
+    parser.AddCmd("build", "helptext", callback)
+    parser.AddCmd("test", "helptext", func(p *ArgParser) {
+        ran = true
+    })
+    err := tryParse(parser, []string{"test", "--release"})
+    if err == nil || !strings.Contains(err.Error(), "can only be used with the 'build' command") || ran {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Markdown help.
+// -------------------------------------------------------------------------
+
+
+func TestHelpMarkdown(t *testing.T) {
+    parser := NewParser("helptext", "")
+    parser.AddStr("string s", "default")
+    parser.AddIntList("int", true)
+    cmdParser := parser.AddCmd("cmd alias", "cmd helptext", callback)
+    parser.AddStr("out o", "-").Desc("Output file.").Metavar("FILE")
+    parser.SetArgsMetavar("INPUT...")
+    cmdParser.AddFlag("bool")
+    md := parser.HelpMarkdown()
+    if !strings.Contains(md, "| `--string`, `-s` | str | `default` |") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "| `--out FILE`, `-o FILE` | str | `-` | Output file. |") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "Arguments: `INPUT...`") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "| `--int` | int list (greedy) |  |") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "### " + progName() + " cmd\n") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "Aliases: `alias`") {
+        t.Fail()
+    }
+    if !strings.Contains(md, "| `--bool` | flag | `false` |") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Help destination.
+// -------------------------------------------------------------------------
+
+
+func TestSetHelpDestination(t *testing.T) {
+    var outBuf, helpBuf strings.Builder
+    parser := NewParser("Root help.", "")
+    parser.AddCmd("cmd", "Command help.", callback)
+    parser.SetOut(&outBuf)
+    parser.SetHelpDestination(&helpBuf)
+    code := catchExit(func() {
+        parser.ParseArgs([]string{"--help"})
+    })
+    if code != 0 || helpBuf.String() != "Root help.\n" || outBuf.String() != "" {
+        t.Fatalf("got %v %q %q", code, helpBuf.String(), outBuf.String())
+    }
+    helpBuf.Reset()
+    code = catchExit(func() {
+        parser.ParseArgs([]string{"cmd", "--help"})
+    })
+    if code != 0 || helpBuf.String() != "Command help.\n" || outBuf.String() != "" {
+        t.Fatalf("got %v %q %q", code, helpBuf.String(), outBuf.String())
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Greedy lists and the -- terminator.
+// -------------------------------------------------------------------------
+
+
+func TestGreedyListStopsAtTerminator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("str", true)
+    parser.AddFlag("bool")
+    parser.ParseArgs([]string{"--str", "a", "b", "--", "c", "--bool"})
+    if parser.LenList("str") != 2 {
+        t.Fail()
+    }
+    if parser.LenArgs() != 2 {
+        t.Fail()
+    }
+    if parser.GetArg(1) != "--bool" {
+        t.Fail()
+    }
+    if parser.GetFlag("bool") != false {
+        t.Fail()
+    }
+}
+
+
+func TestGreedyListConsumesTerminator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("str s", true)
+    parser.AddFlag("bool")
+    parser.SetGreedyStopAtTerminator("str", false)
+    parser.ParseArgs([]string{"-s", "a", "b", "--", "c", "--bool"})
+    if parser.LenList("str") != 4 {
+        t.Fail()
+    }
+    if parser.GetStrList("str")[3] != "--bool" {
+        t.Fail()
+    }
+    if parser.HasArgs() != false {
+        t.Fail()
+    }
+    if parser.GetFlag("bool") != false {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Option types.
+// -------------------------------------------------------------------------
+
+
+func TestTypeOf(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool b")
+    parser.AddStr("string", "default")
+    parser.AddIntList("int", false)
+    parser.AddFloatList("float", true)
+    if parser.TypeOf("b") != "flag" {
+        t.Fail()
+    }
+    if parser.TypeOf("string") != "str" {
+        t.Fail()
+    }
+    if parser.TypeOf("int") != "int" {
+        t.Fail()
+    }
+    if parser.TypeOf("float") != "float" {
+        t.Fail()
+    }
+}
+
+
+func TestIsList(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool")
+    parser.AddFlagList("bools")
+    parser.AddStr("string", "default")
+    parser.AddStrList("strings", false)
+    if parser.IsList("bool") != false {
+        t.Fail()
+    }
+    if parser.IsList("bools") != true {
+        t.Fail()
+    }
+    if parser.IsList("string") != false {
+        t.Fail()
+    }
+    if parser.IsList("strings") != true {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// IP address options.
+// -------------------------------------------------------------------------
+
+
+func TestIPOptionEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIP("ip", net.ParseIP("127.0.0.1"))
+    parser.ParseArgs([]string{})
+    if !parser.GetIP("ip").Equal(net.ParseIP("127.0.0.1")) {
+        t.Fail()
+    }
+}
+
+
+func TestIPOptionIPv4(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIP("ip i", nil)
+    parser.ParseArgs([]string{"-i", "0.0.0.0"})
+    if !parser.GetIP("ip").Equal(net.IPv4zero) {
+        t.Fail()
+    }
+}
+
+
+func TestIPOptionIPv6(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIP("ip", nil)
+    parser.ParseArgs([]string{"--ip", "::1"})
+    if !parser.GetIP("ip").Equal(net.IPv6loopback) {
+        t.Fail()
+    }
+}
+
+
+func TestIPListGreedy(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIPList("ip", true)
+    parser.ParseArgs([]string{"--ip", "10.0.0.1", "::1", "--ip", "10.0.0.2"})
+    if parser.LenList("ip") != 3 {
+        t.Fail()
+    }
+    if !parser.GetIPList("ip")[1].Equal(net.IPv6loopback) {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// URL options.
+// -------------------------------------------------------------------------
+
+
+func TestURLOptionEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddURL("url", nil)
+    parser.ParseArgs([]string{})
+    if parser.GetURL("url") != nil {
+        t.Fail()
+    }
+}
+
+
+func TestURLOptionLongform(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddURL("url u", nil)
+    parser.SetAllowedSchemes("url", "http", "https")
+    parser.ParseArgs([]string{"--url", "https://example.com/path"})
+    if parser.GetURL("u").Host != "example.com" {
+        t.Fail()
+    }
+    if parser.GetURL("u").Path != "/path" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Option dependencies.
+// -------------------------------------------------------------------------
+
+
+func TestRequireIfSatisfied(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.AddStr("cert", "")
+    parser.RequireIf("tls", "cert")
+    parser.ParseArgs([]string{"--tls", "--cert", "file.pem"})
+    if parser.GetStr("cert") != "file.pem" {
+        t.Fail()
+    }
+}
+
+
+func TestRequireIfInactive(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.AddStr("cert", "")
+    parser.RequireIf("tls", "cert")
+    parser.ParseArgs([]string{})
+    if parser.Found("cert") != false {
+        t.Fail()
+    }
+}
+
+
+func TestRequireUnlessSatisfied(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("anonymous")
+    parser.AddStr("user", "")
+    parser.RequireUnless("anonymous", "user")
+    parser.ParseArgs([]string{"--anonymous"})
+    if parser.Found("user") != false {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Value predicates.
+// -------------------------------------------------------------------------
+
+
+func TestValuePredicate(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("string s", "default")
+    parser.SetValuePredicate("string", func(token string) bool {
+        return true
+    })
+    parser.ParseArgs([]string{"-s", "--value"})
+    if parser.GetStr("string") != "--value" {
+        t.Fail()
+    }
+}
+
+
+func TestValuePredicateGreedy(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("str", true)
+    parser.AddFlag("bool")
+    parser.SetValuePredicate("str", func(token string) bool {
+        return strings.HasPrefix(token, "--x-")
+    })
+    parser.ParseArgs([]string{"--str", "--x-a", "--x-b", "--bool", "foo"})
+    if parser.LenList("str") != 2 {
+        t.Fail()
+    }
+    if parser.GetFlag("bool") != true {
+        t.Fail()
+    }
+    if parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Version info.
+// -------------------------------------------------------------------------
+
+
+func TestVersionTextSimple(t *testing.T) {
+    parser := NewParser("", "1.2.3")
+    if parser.versionText() != "1.2.3" {
+        t.Fail()
+    }
+}
+
+
+func TestVersionTextInfo(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetVersionInfo("v1.2.3", "abc123", "2024-01-01")
+    expected := progName() + " v1.2.3\ncommit abc123\nbuilt 2024-01-01"
+    if parser.versionText() != expected {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Positional argument validation.
+// -------------------------------------------------------------------------
+
+
+func TestArgsValidator(t *testing.T) {
+    var validated []string
+    parser := NewParser("", "")
+    parser.SetArgsValidator(func(args []string) error {
+        validated = args
+        return nil
+    })
+    parser.ParseArgs([]string{"foo", "bar"})
+    if len(validated) != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestArgsValidatorBeforeCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.SetArgsValidator(func(args []string) error {
+        return fmt.Errorf("no arguments allowed")
+    })
+    parser.AddCmd("cmd", "", func(p *ArgParser) {
+        ran = true
+    })
+    err := tryParse(parser, []string{"cmd"})
+    if err == nil || err.Error() != "no arguments allowed" || ran {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Trailing capture.
+// -------------------------------------------------------------------------
+
+
+func TestTrailingCapture(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("cmd c", false)
+    parser.AddFlag("bool")
+    parser.SetTrailingCapture("cmd")
+    parser.ParseArgs([]string{"foo", "--cmd", "ls", "-la", "--bool", "--", "/tmp"})
+    if parser.LenList("cmd") != 5 {
+        t.Fail()
+    }
+    if parser.GetStrList("cmd")[1] != "-la" {
+        t.Fail()
+    }
+    if parser.GetFlag("bool") != false {
+        t.Fail()
+    }
+    if parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestTrailingCaptureEquals(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("cmd c", false)
+    parser.SetTrailingCapture("cmd")
+    parser.ParseArgs([]string{"-c=ls", "-la"})
+    if parser.LenList("cmd") != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Counting options and commands.
+// -------------------------------------------------------------------------
+
+
+func TestNumOptions(t *testing.T) {
+    parser := NewParser("", "")
+    if parser.NumOptions() != 0 {
+        t.Fail()
+    }
+    parser.AddFlag("bool b")
+    parser.AddStr("string s str", "default")
+    parser.AddIntList("int", false)
+    if parser.NumOptions() != 3 {
+        t.Fail()
+    }
+}
+
+
+func TestNumCommands(t *testing.T) {
+    parser := NewParser("", "")
+    if parser.NumCommands() != 0 {
+        t.Fail()
+    }
+    parser.AddCmd("foo f", "helptext", callback)
+    parser.AddCmd("bar", "helptext", callback)
+    if parser.NumCommands() != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Boolean values.
+// -------------------------------------------------------------------------
+
+
+func TestParseBool(t *testing.T) {
+    for _, token := range []string{"true", "YES", "On", "y", "1"} {
+        if value, err := parseBool(token); err != nil || value != true {
+            t.Errorf("parseBool(%q) != true", token)
+        }
+    }
+    for _, token := range []string{"false", "No", "OFF", "n", "0"} {
+        if value, err := parseBool(token); err != nil || value != false {
+            t.Errorf("parseBool(%q) != false", token)
+        }
+    }
+    if _, err := parseBool("maybe"); err == nil {
+        t.Fail()
+    }
+}
+
+
+func TestBoolOptionEquals(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool b")
+    parser.AddFlag("other")
+    parser.ParseArgs([]string{"--bool=yes", "--other=off"})
+    if parser.GetFlag("bool") != true {
+        t.Fail()
+    }
+    if parser.GetFlag("other") != false {
+        t.Fail()
+    }
+    if parser.Found("other") != true {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Writing the parser's string representation.
+// -------------------------------------------------------------------------
+
+
+func TestWriteTo(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool")
+    parser.ParseArgs([]string{"foo"})
+    var builder strings.Builder
+    n, err := parser.WriteTo(&builder)
+    if err != nil {
+        t.Fail()
+    }
+    if builder.String() != parser.String() + "\n" {
+        t.Fail()
+    }
+    if int(n) != builder.Len() {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Setting options from a map.
+// -------------------------------------------------------------------------
+
+
+func TestSetFromMap(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool")
+    parser.AddStr("string s", "default")
+    parser.AddInt("int", 101)
+    parser.AddInt("other", 101)
+    parser.SetFromMap(map[string]string{
+        "bool": "yes",
+        "s": "value",
+        "int": "202",
+    })
+    if parser.GetFlag("bool") != true {
+        t.Fail()
+    }
+    if parser.GetStr("string") != "value" {
+        t.Fail()
+    }
+    if parser.GetInt("int") != 202 || parser.Found("int") != true {
+        t.Fail()
+    }
+    if parser.GetInt("other") != 101 || parser.Found("other") != false {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Single-dash long options.
+// -------------------------------------------------------------------------
+
+
+func TestSingleDashLongOptions(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SingleDashLongOptions()
+    parser.AddFlag("verbose")
+    parser.AddStr("output", "default")
+    parser.ParseArgs([]string{"-verbose", "-output", "file"})
+    if parser.GetFlag("verbose") != true {
+        t.Fail()
+    }
+    if parser.GetStr("output") != "file" {
+        t.Fail()
+    }
+}
+
+
+func TestSingleDashLongOptionsFallback(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SingleDashLongOptions()
+    parser.AddFlag("ab")
+    parser.AddFlag("a")
+    parser.AddFlag("b")
+    parser.AddFlag("c")
+    parser.ParseArgs([]string{"-bc"})
+    if parser.GetFlag("ab") != false {
+        t.Fail()
+    }
+    if parser.GetFlag("b") != true || parser.GetFlag("c") != true {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Unique lists.
+// -------------------------------------------------------------------------
+
+
+func TestUniqueStringList(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("tag t", true)
+    parser.SetUnique("tag")
+    parser.ParseArgs([]string{"--tag", "b", "a", "b", "-t", "a", "c"})
+    if parser.LenList("tag") != 3 {
+        t.Fail()
+    }
+    tags := parser.GetStrList("tag")
+    if tags[0] != "b" || tags[1] != "a" || tags[2] != "c" {
+        t.Fail()
+    }
+}
+
+
+func TestUniqueIntList(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntList("int", false)
+    parser.SetUnique("int")
+    parser.ParseArgs([]string{"--int", "1", "--int", "0x1", "--int", "2"})
+    if parser.LenList("int") != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Canonical names.
+// -------------------------------------------------------------------------
+
+
+func TestCanonical(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("output o out", "default")
+    if parser.Canonical("o") != "output" {
+        t.Fail()
+    }
+    if parser.Canonical("out") != "output" {
+        t.Fail()
+    }
+    if parser.Canonical("output") != "output" {
+        t.Fail()
+    }
+    if parser.Canonical("missing") != "" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Partial parsing.
+// -------------------------------------------------------------------------
+
+
+// Parses args, returning a parse failure as an error instead of exiting.
+func tryParse(parser *ArgParser, args []string) error {
+    return catch(func() {
+        parser.parseStream(newArgStream(args))
+    })
+}
+
+
+// Like tryParse() but also returns the parser responsible for any failure.
+func tryParseFailed(parser *ArgParser, args []string) (*ArgParser, error) {
+    stream := newArgStream(args)
+    err := catch(func() {
+        parser.parseStream(stream)
+    })
+    return stream.parser, err
+}
+
+
+func TestParsePartial(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool b")
+    parser.AddStr("string s", "default")
+    leftover, err := parser.ParsePartial([]string{
+        "-b", "foo", "--string", "value", "--other", "bar", "-b",
+    })
+    if err != nil {
+        t.Fail()
+    }
+    if len(leftover) != 3 || leftover[0] != "--other" {
+        t.Fail()
+    }
+    if parser.GetStr("string") != "value" {
+        t.Fail()
+    }
+    if parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestParsePartialCondensed(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("a")
+    parser.AddFlag("b")
+    leftover, _ := parser.ParsePartial([]string{"-ab", "-ax", "-b"})
+    if len(leftover) != 2 || leftover[0] != "-ax" {
+        t.Fail()
+    }
+}
+
+
+func TestParsePartialNoLeftover(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool")
+    leftover, err := parser.ParsePartial([]string{"--bool", "foo"})
+    if err != nil || len(leftover) != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestParsePartialError(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("int", 101)
+    _, err := parser.ParsePartial([]string{"--int", "foo"})
+    if err == nil {
+        t.Fail()
+    }
+    if err.Error() != "cannot parse 'foo' as an integer" {
+        t.Fail()
+    }
+}
+
+
+func TestScopedFlagWithoutCommand(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddScopedFlag("bool", "cmd")
+    parser.AddCmd("cmd", "helptext", callback)
+    parser.AddCmd("other", "helptext", callback)
+    if tryParse(parser, []string{"--bool", "other"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestRequireIfUnregistered(t *testing.T) {
+    var errBuf strings.Builder
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.SetErr(&errBuf)
+    code := catchExit(func() {
+        parser.RequireIf("tls", "cert")
+    })
+    if code != 1 || !strings.Contains(errBuf.String(), "'cert'") {
+        t.Fail()
+    }
+}
+
+
+func TestRequireIfBeforeCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.AddStr("cert", "")
+    parser.RequireIf("tls", "cert")
+    parser.AddCmd("cmd", "", func(p *ArgParser) {
+        ran = true
+    })
+    if tryParse(parser, []string{"--tls", "cmd"}) == nil || ran {
+        t.Fail()
+    }
+}
+
+
+func TestRequireIfUnsatisfied(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.AddStr("cert", "")
+    parser.RequireIf("tls", "cert")
+    err := tryParse(parser, []string{"--tls"})
+    if err == nil || err.Error() != "--cert is required when --tls is set" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Completion spec.
+// -------------------------------------------------------------------------
+
+
+func TestCompletionSpec(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool b").Desc("A flag.")
+    cmdParser := parser.AddCmd("cmd c", "helptext", callback)
+    cmdParser.AddIntList("int", true)
+    cmdParser.AddStr("out", "").Metavar("FILE")
+    cmdParser.SetArgsMetavar("INPUT...")
+    var spec struct {
+        Options []struct {
+            Name string
+            Aliases []string
+            Type string
+            TakesValue bool
+            Metavar string
+            Description string
+        }
+        Commands []struct {
+            Name string
+            Aliases []string
+            Args string
+            Options []struct {
+                Name string
+                List bool
+                TakesValue bool
+                Metavar string
+            }
+        }
+    }
+    if err := json.Unmarshal(parser.CompletionSpec(), &spec); err != nil {
+        t.Fatal(err)
+    }
+    if len(spec.Options) != 1 || spec.Options[0].Name != "bool" {
+        t.Fail()
+    }
+    if spec.Options[0].Aliases[0] != "b" || spec.Options[0].TakesValue {
+        t.Fail()
+    }
+    if spec.Options[0].Description != "A flag." || spec.Options[0].Metavar != "" {
+        t.Fail()
+    }
+    if len(spec.Commands) != 1 || spec.Commands[0].Aliases[0] != "c" {
+        t.Fail()
+    }
+    if !spec.Commands[0].Options[0].List || !spec.Commands[0].Options[0].TakesValue {
+        t.Fail()
+    }
+    if spec.Commands[0].Options[0].Metavar != "<int>" || spec.Commands[0].Options[1].Metavar != "FILE" {
+        t.Fail()
+    }
+    if spec.Commands[0].Args != "INPUT..." {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Confirmation flags.
+// -------------------------------------------------------------------------
+
+
+// Runs fn with stdin replaced by the specified input.
+func withStdin(input string, interactive bool, fn func()) {
+    oldStdin, oldInteractive := stdin, isInteractive
+    defer func() {
+        stdin, isInteractive = oldStdin, oldInteractive
+        pendingLine = nil
+    }()
+    stdin = bufio.NewReader(strings.NewReader(input))
+    isInteractive = func() bool { return interactive }
+    fn()
+}
+
+
+func TestConfirmFlagPresent(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddConfirm("yes y", "Really?")
+    withStdin("", false, func() {
+        if tryParse(parser, []string{"-y"}) != nil {
+            t.Fail()
+        }
+    })
+}
+
+
+func TestConfirmNonInteractive(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddConfirm("yes y", "Really?")
+    withStdin("", false, func() {
+        if tryParse(parser, []string{}) == nil {
+            t.Fail()
+        }
+    })
+}
+
+
+func TestConfirmAccepted(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddConfirm("yes y", "Really?")
+    withStdin("yes\n", true, func() {
+        if tryParse(parser, []string{}) != nil {
+            t.Fail()
+        }
+    })
+}
+
+
+func TestConfirmDeclined(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddConfirm("yes y", "Really?")
+    withStdin("n\n", true, func() {
+        err := tryParse(parser, []string{})
+        if err == nil || err.Error() != "aborted" {
+            t.Fail()
+        }
+    })
+}
+
+
+func TestConfirmBeforeCommandCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddConfirm("yes y", "Really?")
+    parser.AddCmd("cmd", "", func(p *ArgParser) {
+        ran = true
+    })
+    withStdin("n\n", true, func() {
+        err := tryParse(parser, []string{"cmd"})
+        if err == nil || err.Error() != "aborted" || ran {
+            t.Fail()
+        }
+    })
+    withStdin("y\n", true, func() {
+        if tryParse(parser, []string{"cmd"}) != nil || !ran {
+            t.Fail()
+        }
+    })
+}
+
+
+func TestConfirmTimeout(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "helptext", callback)
+    cmdParser.AddConfirm("yes y", "Really?")
+    parser.SetStdinTimeout(time.Second)
+    reader, writer := io.Pipe()
+    oldStdin, oldInteractive, oldTimer := stdin, isInteractive, stdinTimer
+    defer func() {
+        stdin, isInteractive, stdinTimer = oldStdin, oldInteractive, oldTimer
+        pendingLine = nil
+    }()
+    stdin = bufio.NewReader(reader)
+    isInteractive = func() bool { return true }
+
+    // The timer fires at once, before any input can arrive.
+    expired := make(chan time.Time, 1)
+    expired <- time.Time{}
+    stdinTimer = func(time.Duration) <-chan time.Time {
+        return expired
+    }
+    err := tryParse(parser, []string{"cmd"})
+    if err == nil || !strings.Contains(err.Error(), "timed out") {
+        t.Fatal(err)
+    }
+
+    // Input arriving after the timeout is returned by the next read.
+    go writer.Write([]byte("yes\n"))
+    stdinTimer = oldTimer
+    if line, err := readLine(0); err != nil || line != "yes" {
+        t.Fatalf("got %q %v", line, err)
+    }
+}
+
+
+func TestReadLineKeepsBufferedInput(t *testing.T) {
+    withStdin("first\nsecond\n", true, func() {
+        for _, expected := range []string{"first", "second"} {
+            if line, err := readLine(0); err != nil || line != expected {
+                t.Fatalf("got %q %v", line, err)
+            }
+        }
+    })
+}
+
+
+// -------------------------------------------------------------------------
+// Joined string lists.
+// -------------------------------------------------------------------------
+
+
+func TestStrListJoined(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("str", true)
+    parser.ParseArgs([]string{"--str", "a", "b", "c"})
+    if parser.GetStrListJoined("str", " ") != "a b c" {
+        t.Fail()
+    }
+}
+
+
+func TestStrListJoinedEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("str", true)
+    parser.ParseArgs([]string{})
+    if parser.GetStrListJoined("str", ",") != "" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Command providers.
+// -------------------------------------------------------------------------
+
+
+func TestCommandProvider(t *testing.T) {
+    var called *ArgParser
+    plugin := NewParser("plugin helptext", "")
+    plugin.AddFlag("bool")
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "helptext", callback)
+    parser.SetCommandProvider(func(name string) (*ArgParser, func(*ArgParser), bool) {
+        if name == "plugin" {
+            return plugin, func(p *ArgParser) { called = p }, true
+        }
+        return nil, nil, false
+    })
+    parser.ParseArgs([]string{"plugin", "--bool"})
+    if called != plugin {
+        t.Fail()
+    }
+    if parser.GetCmdName() != "plugin" || parser.GetCmdParser() != plugin {
+        t.Fail()
+    }
+    if plugin.GetFlag("bool") != true || plugin.GetParent() != parser {
+        t.Fail()
+    }
+}
+
+
+func TestCommandProviderDeclined(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetCommandProvider(func(name string) (*ArgParser, func(*ArgParser), bool) {
+        return nil, nil, false
+    })
+    parser.ParseArgs([]string{"foo"})
+    if parser.HasCmd() != false || parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Unknown option handlers.
+// -------------------------------------------------------------------------
+
+
+func TestUnknownOptionDefault(t *testing.T) {
+    parser := NewParser("", "")
+    err := tryParse(parser, []string{"--foo=bar"})
+    if err == nil || err.Error() != "--foo is not a recognised option" {
+        t.Fail()
+    }
+}
+
+
+func TestUnknownOptionHandler(t *testing.T) {
+    var unknown []string
+    parser := NewParser("", "")
+    parser.AddFlag("bool b")
+    parser.SetUnknownOptionHandler(func(name string, stream *ArgStream) error {
+        unknown = append(unknown, name)
+        if name == "--foo" && stream.HasNextValue() {
+            unknown = append(unknown, stream.Next())
+        }
+        return nil
+    })
+    err := tryParse(parser, []string{"--foo", "value", "-bx", "--bar=baz", "arg"})
+    if err != nil {
+        t.Fail()
+    }
+    if strings.Join(unknown, " ") != "--foo value -x --bar=baz" {
+        t.Fail()
+    }
+    if parser.GetFlag("bool") != true || parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestUnknownOptionHandlerError(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetUnknownOptionHandler(func(name string, stream *ArgStream) error {
+        return fmt.Errorf("no options allowed here")
+    })
+    err := tryParse(parser, []string{"--foo"})
+    if err == nil || err.Error() != "no options allowed here" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Stripped prefixes.
+// -------------------------------------------------------------------------
+
+
+func TestStripPrefix(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetStripPrefix("myapp-")
+    parser.AddFlag("verbose")
+    parser.AddStr("output", "default")
+    parser.AddInt("level", 1)
+    parser.ParseArgs([]string{"--myapp-verbose", "--myapp-output=file", "--level", "2"})
+    if parser.GetFlag("verbose") != true {
+        t.Fail()
+    }
+    if parser.GetStr("output") != "file" {
+        t.Fail()
+    }
+    if parser.GetInt("level") != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Ambiguity warnings.
+// -------------------------------------------------------------------------
+
+
+func TestAmbiguityWarning(t *testing.T) {
+    var buf strings.Builder
+    parser := NewParser("", "")
+    parser.SetAmbiguityWarning(&buf)
+    parser.AddStrList("files", true)
+    parser.AddCmd("build", "", callback)
+    parser.ParseArgs([]string{"--files", "a", "b", "build"})
+    if parser.LenList("files") != 3 {
+        t.Fail()
+    }
+    if !strings.Contains(buf.String(), "'build'") {
+        t.Fail()
+    }
+}
+
+
+func TestAmbiguityWarningSilent(t *testing.T) {
+    var buf strings.Builder
+    parser := NewParser("", "")
+    parser.SetAmbiguityWarning(&buf)
+    parser.AddStrList("files", true)
+    parser.AddCmd("build", "", callback)
+    parser.ParseArgs([]string{"--files", "a", "b"})
+    if buf.Len() != 0 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Help and version accessors.
+// -------------------------------------------------------------------------
+
+
+func TestGetHelpText(t *testing.T) {
+    parser := NewParser("  Usage: app  \n", "1.2.3")
+    if parser.GetHelpText() != "Usage: app" {
+        t.Fail()
+    }
+    if parser.GetVersion() != "1.2.3" {
+        t.Fail()
+    }
+}
+
+
+func TestGetHelpTextCommand(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "Command help.", callback)
+    if cmdParser.GetHelpText() != "Command help." || parser.GetHelpText() != "" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Delimited lists.
+// -------------------------------------------------------------------------
+
+
+func TestDelimiterSeparateValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("tags", false)
+    parser.SetDelimiter("tags", ",")
+    parser.ParseArgs([]string{"--tags", "a,b,c"})
+    if parser.LenList("tags") != 3 || parser.GetStrList("tags")[2] != "c" {
+        t.Fail()
+    }
+}
+
+
+func TestDelimiterEqualsValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntList("ints i", false)
+    parser.SetDelimiter("ints", ",")
+    parser.ParseArgs([]string{"--ints=1,2,3", "-i=4"})
+    if parser.LenList("ints") != 4 || parser.GetIntList("ints")[1] != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestDelimiterScalarEqualsValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("tags", "default")
+    parser.ParseArgs([]string{"--tags=a,b,c"})
+    if parser.GetStr("tags") != "a,b,c" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Options before arguments.
+// -------------------------------------------------------------------------
+
+
+func TestOptionsBeforeArgs(t *testing.T) {
+    parser := NewParser("", "")
+    parser.OptionsBeforeArgs()
+    parser.AddFlag("bool b")
+    parser.ParseArgs([]string{"-b", "foo", "bar", "-", "-1"})
+    if parser.GetFlag("bool") != true || parser.LenArgs() != 4 {
+        t.Fail()
+    }
+}
+
+
+func TestOptionsBeforeArgsError(t *testing.T) {
+    parser := NewParser("", "")
+    parser.OptionsBeforeArgs()
+    parser.AddFlag("bool b")
+    err := tryParse(parser, []string{"foo", "-b"})
+    if err == nil || err.Error() != "options must come before arguments" {
+        t.Fail()
+    }
+}
+
+
+func TestOptionsBeforeArgsTerminator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.OptionsBeforeArgs()
+    parser.AddFlag("bool b")
+    parser.ParseArgs([]string{"foo", "--", "-b"})
+    if parser.GetFlag("bool") != false || parser.LenArgs() != 2 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Integer maps.
+// -------------------------------------------------------------------------
+
+
+func TestIntMapEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntMap("limits")
+    parser.ParseArgs([]string{})
+    if len(parser.GetIntMap("limits")) != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestIntMap(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntMap("limits l")
+    parser.ParseArgs([]string{"--limits", "cpu=2,mem=4", "-l=cpu=3"})
+    limits := parser.GetIntMap("limits")
+    if len(limits) != 2 || limits["cpu"] != 3 || limits["mem"] != 4 {
+        t.Fail()
+    }
+}
+
+
+func TestIntMapDisplayType(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntMap("limits")
+    if parser.IsList("limits") {
+        t.Fail()
+    }
+    if !strings.Contains(parser.HelpMarkdown(), "| `--limits` | intmap |") {
+        t.Fail()
+    }
+    if !strings.Contains(parser.GenerateManPage("app", "1"), "\nintmap\n") {
+        t.Fail()
+    }
+}
+
+
+func TestIntMapBadValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntMap("limits")
+    err := tryParse(parser, []string{"--limits", "cpu=2,mem=lots"})
+    if err == nil || !strings.Contains(err.Error(), "'mem'") {
+        t.Fail()
+    }
+}
+
+
+func TestIntMapMissingEquals(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntMap("limits")
+    err := tryParse(parser, []string{"--limits", "cpu"})
+    if err == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Root actions.
+// -------------------------------------------------------------------------
+
+
+func TestRootActionNoCommand(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", callback)
+    parser.SetRootAction(func(p *ArgParser) {
+        ran = p == parser
+    })
+    parser.ParseArgs([]string{"foo"})
+    if !ran {
+        t.Fail()
+    }
+}
+
+
+func TestRootActionWithCommand(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", callback)
+    parser.SetRootAction(func(p *ArgParser) {
+        ran = true
+    })
+    parser.ParseArgs([]string{"cmd"})
+    if ran {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// List sentinels.
+// -------------------------------------------------------------------------
+
+
+func TestListSentinel(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("includes", true)
+    parser.AddStrList("excludes", true)
+    parser.SetListSentinel("includes", "++")
+    parser.ParseArgs([]string{"--includes", "a", "b", "++", "c", "--excludes", "d"})
+    if parser.LenList("includes") != 2 || parser.LenList("excludes") != 1 {
+        t.Fail()
+    }
+    if parser.LenArgs() != 1 || parser.GetArg(0) != "c" {
+        t.Fail()
+    }
+}
+
+
+func TestListSentinelUnused(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("includes", true)
+    parser.SetListSentinel("includes", "++")
+    parser.ParseArgs([]string{"--includes", "a", "b", "c"})
+    if parser.LenList("includes") != 3 || parser.LenArgs() != 0 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// String sets.
+// -------------------------------------------------------------------------
+
+
+func TestStrSet(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrSet("feature f")
+    parser.ParseArgs([]string{"--feature", "x", "-f", "y", "--feature", "x"})
+    members := parser.GetSet("feature")
+    if len(members) != 2 || members[0] != "x" || members[1] != "y" {
+        t.Fail()
+    }
+    if !parser.HasSetMember("feature", "y") || parser.HasSetMember("feature", "z") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Parsing a single command.
+// -------------------------------------------------------------------------
+
+
+func TestParseCommand(t *testing.T) {
+    var found *ArgParser
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd c", "", func(p *ArgParser) {
+        found = p
+    })
+    cmdParser.AddInt("int i", 1)
+    err := parser.ParseCommand("c", []string{"-i", "2", "foo"})
+    if err != nil || found != cmdParser {
+        t.Fail()
+    }
+    if cmdParser.GetInt("int") != 2 || cmdParser.LenArgs() != 1 {
+        t.Fail()
+    }
+    if parser.GetCmdName() != "c" {
+        t.Fail()
+    }
+}
+
+
+func TestParseCommandUnknown(t *testing.T) {
+    parser := NewParser("", "")
+    err := parser.ParseCommand("cmd", []string{})
+    if err == nil || err.Error() != "'cmd' is not a recognised command" {
+        t.Fail()
+    }
+}
+
+
+func TestParseCommandError(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    cmdParser.AddInt("int", 1)
+    err := parser.ParseCommand("cmd", []string{"--int", "foo"})
+    if err == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Man pages.
+// -------------------------------------------------------------------------
+
+
+func TestGenerateManPage(t *testing.T) {
+    parser := NewParser("Usage: app [options]\n.not a macro", "")
+    parser.AddInt("num-items n", 5)
+    parser.AddCmd("build b", "Build \\ things.", callback)
+    page := parser.GenerateManPage("app", "1")
+    if !strings.HasPrefix(page, ".TH \"APP\" \"1\"\n") {
+        t.Fail()
+    }
+    if !strings.Contains(page, "\n\\&.not a macro\n") {
+        t.Fail()
+    }
+    if !strings.Contains(page, "\\fB\\-\\-num\\-items\\fR, \\fB\\-n\\fR") {
+        t.Fail()
+    }
+    if !strings.Contains(page, "int (default: 5)") {
+        t.Fail()
+    }
+    if !strings.Contains(page, ".SH COMMANDS\n.TP\n\\fBbuild\\fR, \\fBb\\fR\n.nf\nBuild \\e things.\n.fi\n") {
+        t.Fail()
+    }
+}
+
+
+func TestGenerateManPageCommands(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("out o", "-").Desc("Output file.").Metavar("FILE")
+    buildParser := parser.AddCmd("build", "Build things.", callback)
+    buildParser.AddFlag("fast").Desc("Skip the checks.")
+    buildParser.SetArgsMetavar("TARGET...")
+    buildParser.AddCmd("docs", "Build the docs.", callback)
+    page := parser.GenerateManPage("app", "1")
+    if !strings.Contains(page, ".TP\n\\fB\\-\\-out\\fR \\fIFILE\\fR, \\fB\\-o\\fR \\fIFILE\\fR\nOutput file.\n.br\nstr (default: \\-)\n") {
+        t.Fail()
+    }
+    if !strings.Contains(page, ".SH \"APP BUILD\"\n\\fBapp build\\fR [options] [command] \\fITARGET...\\fR\n") {
+        t.Fail()
+    }
+    if !strings.Contains(page, ".SS OPTIONS\n.TP\n\\fB\\-\\-fast\\fR\nSkip the checks.\n") {
+        t.Fail()
+    }
+    if !strings.Contains(page, ".SH \"APP BUILD DOCS\"\n\\fBapp build docs\\fR\n.PP\n.nf\nBuild the docs.\n.fi\n") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Indexed options.
+// -------------------------------------------------------------------------
+
+
+func TestIndexedIndexTooLarge(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIndexed("server")
+    err := tryParse(parser, []string{"--server.1000000000.host", "x"})
+    if err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"--server.1000.host=x"}) != nil {
+        t.Fail()
+    }
+}
+
+
+func TestIndexed(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIndexed("server")
+    parser.ParseArgs([]string{
+        "--server.0.host", "a",
+        "--server.0.port=80",
+        "--server.2.host", "b",
+    })
+    servers := parser.GetIndexed("server")
+    if len(servers) != 3 {
+        t.Fail()
+        return
+    }
+    if servers[0]["host"] != "a" || servers[0]["port"] != "80" {
+        t.Fail()
+    }
+    if len(servers[1]) != 0 || servers[2]["host"] != "b" {
+        t.Fail()
+    }
+}
+
+
+func TestIndexedMissingIndex(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIndexed("server")
+    if tryParse(parser, []string{"--server", "a"}) == nil {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"--server.x.host", "a"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestIndexedPartial(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIndexed("server")
+    leftover, err := parser.ParsePartial([]string{"--server.0.host", "a", "--foo"})
+    if err != nil || len(leftover) != 1 || parser.GetIndexed("server")[0]["host"] != "a" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// One-of requirements.
+// -------------------------------------------------------------------------
+
+
+func TestRequireOneOfUnregistered(t *testing.T) {
+    var errBuf strings.Builder
+    parser := NewParser("", "")
+    parser.SetErr(&errBuf)
+    code := catchExit(func() {
+        parser.RequireOneOf(OptionPresent("all"), MinArgs(1))
+    })
+    if code != 1 || !strings.Contains(errBuf.String(), "'all'") {
+        t.Fail()
+    }
+}
+
+
+func TestRequireOneOfBeforeCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddFlag("all")
+    parser.RequireOneOf(OptionPresent("all"), MinArgs(1))
+    parser.AddCmd("cmd", "", func(p *ArgParser) {
+        ran = true
+    })
+    if tryParse(parser, []string{"cmd"}) == nil || ran {
+        t.Fail()
+    }
+}
+
+
+func TestRequireOneOf(t *testing.T) {
+    newParser := func() *ArgParser {
+        parser := NewParser("", "")
+        parser.AddFlag("all a")
+        parser.RequireOneOf(OptionPresent("all"), MinArgs(1))
+        return parser
+    }
+    if tryParse(newParser(), []string{"--all"}) != nil {
+        t.Fail()
+    }
+    if tryParse(newParser(), []string{"foo", "bar"}) != nil {
+        t.Fail()
+    }
+    err := tryParse(newParser(), []string{})
+    if err == nil || err.Error() != "exactly one of --all or at least 1 argument is required" {
+        t.Fail()
+    }
+    if tryParse(newParser(), []string{"-a", "foo"}) == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Snapshots.
+// -------------------------------------------------------------------------
+
+
+func TestDump(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("int i", 1)
+    parser.AddStrList("str", false)
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    cmdParser.AddFlag("bool")
+    parser.ParseArgs([]string{"-i", "2", "-i", "3", "--str", "a", "foo", "cmd", "--bool"})
+    snapshot := parser.Dump()
+    if len(snapshot.Options) != 2 || len(snapshot.Arguments) != 1 {
+        t.Fail()
+        return
+    }
+    intOpt := snapshot.Options[0]
+    if intOpt.Name != "int" || intOpt.Aliases[0] != "i" || intOpt.Type != "int" {
+        t.Fail()
+    }
+    if !intOpt.Found || intOpt.IsList || len(intOpt.Values) != 1 || intOpt.Values[0] != "3" {
+        t.Fail()
+    }
+    if !snapshot.Options[1].IsList || snapshot.Options[1].Values[0] != "a" {
+        t.Fail()
+    }
+    if snapshot.Command != "cmd" || snapshot.CommandSnapshot == nil {
+        t.Fail()
+        return
+    }
+    if snapshot.CommandSnapshot.Options[0].Values[0] != "true" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Toggles.
+// -------------------------------------------------------------------------
+
+
+func TestToggleMissing(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddToggle("color c", true)
+    parser.ParseArgs([]string{})
+    if parser.GetFlag("color") != true || parser.Found("color") {
+        t.Fail()
+    }
+}
+
+
+func TestToggleOnce(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddToggle("color c", true)
+    parser.ParseArgs([]string{"--color"})
+    if parser.GetFlag("color") != false {
+        t.Fail()
+    }
+}
+
+
+func TestToggleTwice(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddToggle("color c", true)
+    parser.ParseArgs([]string{"--color", "-c"})
+    if parser.GetFlag("color") != true || !parser.Found("color") {
+        t.Fail()
+    }
+}
+
+
+func TestToggleCondensed(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddToggle("color c", false)
+    parser.ParseArgs([]string{"-ccc"})
+    if parser.GetFlag("color") != true {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Usage footers.
+// -------------------------------------------------------------------------
+
+
+func TestUsageFooterDefault(t *testing.T) {
+    parser := NewParser("Help!", "")
+    cmdParser := parser.AddCmd("cmd", "Command help.", callback)
+    cmdParser.AddInt("int", 1)
+    failed, _ := tryParseFailed(parser, []string{"cmd", "--int", "foo"})
+    footer := failed.usageFooterText()
+    if footer != fmt.Sprintf("Run '%v cmd --help' for more information.", progName()) {
+        t.Fail()
+    }
+}
+
+
+func TestUsageFooterNoHelp(t *testing.T) {
+    parser := NewParser("", "")
+    failed, _ := tryParseFailed(parser, []string{"--foo"})
+    if failed.usageFooterText() != "" {
+        t.Fail()
+    }
+}
+
+
+func TestUsageFooterCustom(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.SetUsageFooter("See the manual.")
+    parser.AddCmd("cmd", "Command help.", callback)
+    failed, _ := tryParseFailed(parser, []string{"cmd", "--foo"})
+    if failed.usageFooterText() != "See the manual." {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Value sources.
+// -------------------------------------------------------------------------
+
+
+func TestSourceDefault(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("int", 1)
+    parser.SetEnv("int", "CLIO_TEST_UNSET_INT")
+    parser.ParseArgs([]string{})
+    if parser.GetInt("int") != 1 || parser.SourceOf("int") != SourceDefault {
+        t.Fail()
+    }
+}
+
+
+func TestSourceEnvOverConfig(t *testing.T) {
+    t.Setenv("CLIO_TEST_INT", "2")
+    parser := NewParser("", "")
+    parser.AddInt("int", 1)
+    parser.AddStr("str s", "default")
+    parser.SetEnv("int", "CLIO_TEST_INT")
+    parser.SetConfig(map[string]string{"int": "3", "s": "config"})
+    parser.ParseArgs([]string{})
+    if parser.GetInt("int") != 2 || parser.SourceOf("int") != SourceEnv {
+        t.Fail()
+    }
+    if parser.GetStr("str") != "config" || parser.SourceOf("str") != SourceConfig {
+        t.Fail()
+    }
+}
+
+
+func TestSourceCLIFirst(t *testing.T) {
+    t.Setenv("CLIO_TEST_INT", "2")
+    parser := NewParser("", "")
+    parser.AddInt("int", 1)
+    parser.SetEnv("int", "CLIO_TEST_INT")
+    parser.ParseArgs([]string{"--int", "4"})
+    if parser.GetInt("int") != 4 || parser.SourceOf("int") != SourceCLI {
+        t.Fail()
+    }
+}
+
+
+func TestSourceCustomOrder(t *testing.T) {
+    t.Setenv("CLIO_TEST_INT", "2")
+    parser := NewParser("", "")
+    parser.AddInt("int", 1)
+    parser.SetEnv("int", "CLIO_TEST_INT")
+    parser.SetSourceOrder(SourceEnv, SourceCLI, SourceDefault)
+    parser.ParseArgs([]string{"--int", "4"})
+    if parser.GetInt("int") != 2 || parser.SourceOf("int") != SourceEnv {
+        t.Fail()
+    }
+}
+
+
+func TestSourceParentBeforeCallback(t *testing.T) {
+    t.Setenv("CLIO_TEST_ROOT", "env")
+    var seenEnv, seenConfig string
+    parser := NewParser("", "")
+    parser.AddStr("root", "def")
+    parser.AddStr("other", "def")
+    parser.SetEnv("root", "CLIO_TEST_ROOT")
+    parser.SetConfig(map[string]string{"other": "config"})
+    parser.AddCmd("run", "", func(p *ArgParser) {
+        seenEnv = p.GetParent().GetStr("root")
+        seenConfig = p.GetParent().GetStr("other")
+    })
+    parser.ParseArgs([]string{"run"})
+    if seenEnv != "env" || seenConfig != "config" {
+        t.Fail()
+    }
+}
+
+
+func TestSourceBadEnvValue(t *testing.T) {
+    t.Setenv("CLIO_TEST_INT", "foo")
+    parser := NewParser("", "")
+    parser.AddInt("int", 1)
+    parser.SetEnv("int", "CLIO_TEST_INT")
+    err := tryParse(parser, []string{})
+    if err == nil || !strings.Contains(err.Error(), "$CLIO_TEST_INT") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Enum lists.
+// -------------------------------------------------------------------------
+
+
+func TestEnumList(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddEnumList("perms p", []string{"read", "write", "exec"}, false)
+    parser.ParseArgs([]string{"--perms", "read,write", "-p=exec"})
+    perms := parser.GetStrList("perms")
+    if len(perms) != 3 || perms[0] != "read" || perms[2] != "exec" {
+        t.Fail()
+    }
+}
+
+
+func TestEnumListGreedy(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddEnumList("perms", []string{"read", "write"}, true)
+    parser.ParseArgs([]string{"--perms", "read", "write"})
+    if parser.LenList("perms") != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestEnumListInvalid(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddEnumList("perms", []string{"read", "write"}, false)
+    err := tryParse(parser, []string{"--perms", "read,delete"})
+    msg := "'delete' is not a valid value for --perms (choose from read, write)"
+    if err == nil || err.Error() != msg {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Arguments files.
+// -------------------------------------------------------------------------
+
+
+func writeArgsFile(t *testing.T, content string) string {
+    path := t.TempDir() + "/args"
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+
+func TestArgsFile(t *testing.T) {
+    path := writeArgsFile(t, "# comment\n--str 'from file'\n--int 1 --list a\n")
+    parser := NewParser("", "")
+    parser.EnableArgsFileOption("args-file")
+    parser.AddStr("str", "default")
+    parser.AddInt("int", 0)
+    parser.AddStrList("list", false)
+    parser.ParseArgs([]string{"--int", "2", "--args-file", path, "--list", "b", "foo"})
+    if parser.GetStr("str") != "from file" || parser.GetInt("int") != 2 {
+        t.Fail()
+    }
+    list := parser.GetStrList("list")
+    if len(list) != 2 || list[0] != "a" || list[1] != "b" {
+        t.Fail()
+    }
+    if parser.GetStr("args-file") != path || parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestArgsFileEquals(t *testing.T) {
+    path := writeArgsFile(t, "--int 1")
+    parser := NewParser("", "")
+    parser.EnableArgsFileOption("args-file")
+    parser.AddInt("int", 0)
+    parser.ParseArgs([]string{"--args-file=" + path})
+    if parser.GetInt("int") != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestArgsFileShortAlias(t *testing.T) {
+    path := writeArgsFile(t, "--int 1")
+    parser := NewParser("", "")
+    parser.EnableArgsFileOption("args-file @")
+    parser.AddInt("int", 0)
+    parser.AddFlag("verbose v")
+    parser.ParseArgs([]string{"-@", path})
+    if parser.GetInt("int") != 1 || parser.GetStr("args-file") != path {
+        t.Fail()
+    }
+    parser = NewParser("", "")
+    parser.EnableArgsFileOption("args-file @")
+    parser.AddInt("int", 0)
+    parser.AddFlag("verbose v")
+    parser.ParseArgs([]string{"-v@", path, "foo"})
+    if parser.GetInt("int") != 1 || !parser.GetFlag("verbose") || parser.LenArgs() != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestArgsFileMissing(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnableArgsFileOption("args-file")
+    err := tryParse(parser, []string{"--args-file", t.TempDir() + "/missing"})
+    if err == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Unused options.
+// -------------------------------------------------------------------------
+
+
+func TestUnusedAfterParse(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("bool b")
+    parser.AddInt("int", 1)
+    parser.AddStr("str", "default")
+    parser.AddStrList("list", false)
+    parser.AddFloat("float", 1.0)
+    parser.ParseArgs([]string{"-b"})
+    parser.SetFloat("float", 2.0)
+    unused := parser.UnusedAfterParse()
+    if len(unused) != 3 || unused[0] != "int" || unused[1] != "list" || unused[2] != "str" {
+        t.Fail()
+    }
+}
+
+
+func TestEnumCaseInsensitive(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddEnumList("format", []string{"json", "yaml"}, false)
+    parser.SetEnumCaseInsensitive("format")
+    parser.ParseArgs([]string{"--format", "JSON,Yaml"})
+    formats := parser.GetStrList("format")
+    if len(formats) != 2 || formats[0] != "json" || formats[1] != "yaml" {
+        t.Fail()
+    }
+}
+
+
+func TestEnumCaseSensitiveByDefault(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddEnumList("format", []string{"json", "yaml"}, false)
+    err := tryParse(parser, []string{"--format", "JSON"})
+    if err == nil || !strings.Contains(err.Error(), "(choose from json, yaml)") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Argument iterators.
+// -------------------------------------------------------------------------
+
+
+func TestArgsIntIter(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"1", "2", "foo", "3"})
+    next := parser.ArgsIntIter()
+    sum := 0
+    for {
+        value, ok, err := next()
+        if err != nil || !ok {
+            break
+        }
+        sum += value
+    }
+    if sum != 3 {
+        t.Fail()
+    }
+    value, ok, err := next()
+    if value != 3 || !ok || err != nil {
+        t.Fail()
+    }
+    if _, ok, err := next(); ok || err != nil {
+        t.Fail()
+    }
+}
+
+
+func TestArgsFloatIter(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"1.5", "foo"})
+    next := parser.ArgsFloatIter()
+    if value, ok, err := next(); value != 1.5 || !ok || err != nil {
+        t.Fail()
+    }
+    if _, ok, err := next(); ok || err == nil {
+        t.Fail()
+    }
+}
+
+
+func TestArgsStrIter(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"foo", "bar"})
+    next := parser.ArgsStrIter()
+    first, _ := next()
+    second, _ := next()
+    _, ok := next()
+    if first != "foo" || second != "bar" || ok {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Help keywords.
+// -------------------------------------------------------------------------
+
+
+func TestHelpKeywordDisabled(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetHelpKeyword("")
+    parser.AddCmd("cmd", "", callback)
+    parser.ParseArgs([]string{"help", "cmd"})
+    if parser.HasCmd() != true || parser.LenArgs() != 1 || parser.GetArg(0) != "help" {
+        t.Fail()
+    }
+}
+
+
+func TestHelpKeywordReplaced(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetHelpKeyword("?")
+    parser.AddCmd("cmd", "", callback)
+    err := tryParse(parser, []string{"?", "foo"})
+    if err == nil || err.Error() != "'foo' is not a recognised command" {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"help"}) != nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Byte deltas.
+// -------------------------------------------------------------------------
+
+
+func TestByteDeltaDefault(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddByteDelta("adjust", 0)
+    parser.ParseArgs([]string{})
+    if parser.GetByteDelta("adjust") != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestByteDeltaNegative(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddByteDelta("adjust a", 0)
+    parser.ParseArgs([]string{"--adjust", "-500MB"})
+    if parser.GetByteDelta("adjust") != -500000000 {
+        t.Fail()
+    }
+}
+
+
+func TestByteDeltaPositive(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddByteDelta("adjust a", 0)
+    parser.ParseArgs([]string{"-a", "+1GiB"})
+    if parser.GetByteDelta("adjust") != 1 << 30 {
+        t.Fail()
+    }
+}
+
+
+func TestByteDeltaInvalid(t *testing.T) {
+    for _, arg := range []string{"500", "500XB", "MB", "+-5MB"} {
+        parser := NewParser("", "")
+        parser.AddByteDelta("adjust", 0)
+        if tryParse(parser, []string{"--adjust=" + arg}) == nil {
+            t.Errorf("expected an error for %v", arg)
+        }
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Middleware.
+// -------------------------------------------------------------------------
+
+
+func TestMiddleware(t *testing.T) {
+    calls := make([]string, 0)
+    logger := func(label string) func(func(*ArgParser)) func(*ArgParser) {
+        return func(next func(*ArgParser)) func(*ArgParser) {
+            return func(p *ArgParser) {
+                calls = append(calls, label + " before")
+                next(p)
+                calls = append(calls, label + " after")
+            }
+        }
+    }
+    parser := NewParser("", "")
+    parser.Use(logger("first"))
+    parser.Use(logger("second"))
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    cmdParser.Use(logger("child"))
+    cmdParser.AddCmd("sub", "", func(p *ArgParser) {
+        calls = append(calls, "sub")
+    })
+    parser.ParseArgs([]string{"cmd", "sub"})
+    expected := []string{
+        "first before", "second before", "child before",
+        "sub",
+        "child after", "second after", "first after",
+        "first before", "second before",
+        "second after", "first after",
+    }
+    if strings.Join(calls, "|") != strings.Join(expected, "|") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Auto-exit.
+// -------------------------------------------------------------------------
+
+
+func TestHelpRequested(t *testing.T) {
+    ran := false
+    parser := NewParser("Help!", "1.0")
+    parser.SetAutoExit(false)
+    parser.AddInt("int", 1)
+    parser.RequireOneOf(OptionPresent("int"))
+    cmdParser := parser.AddCmd("cmd", "Command help.", func(p *ArgParser) {
+        ran = true
+    })
+    parser.ParseArgs([]string{"cmd", "--help", "--int", "foo"})
+    if !parser.HelpRequested() || !cmdParser.HelpRequested() || parser.VersionRequested() {
+        t.Fail()
+    }
+    if ran || parser.GetCmdParser() != cmdParser {
+        t.Fail()
+    }
+}
+
+
+func TestHelpCommandRequested(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.SetAutoExit(false)
+    cmdParser := parser.AddCmd("cmd", "Command help.", callback)
+    parser.ParseArgs([]string{"help", "cmd"})
+    if !parser.HelpRequested() || parser.GetCmdParser() != cmdParser {
+        t.Fail()
+    }
+}
+
+
+func TestVersionRequested(t *testing.T) {
+    parser := NewParser("Help!", "1.0")
+    parser.SetAutoExit(false)
+    parser.ParseArgs([]string{"--version"})
+    if !parser.VersionRequested() || parser.HelpRequested() {
+        t.Fail()
+    }
+}
+
+
+func TestDelimiterEscaped(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("tags", false)
+    parser.SetDelimiter("tags", ",")
+    parser.ParseArgs([]string{"--tags", `a\,b,c`, "--tags=d\\\\,e\\f"})
+    tags := parser.GetStrList("tags")
+    if len(tags) != 4 || tags[0] != "a,b" || tags[1] != "c" {
+        t.Fail()
+        return
+    }
+    if tags[2] != "d\\" || tags[3] != "e\\f" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Shared options.
+// -------------------------------------------------------------------------
+
+
+func TestSharedStrFallback(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    parser.AddSharedStr([]string{"cmd"}, "output o", "default")
+    parser.ParseArgs([]string{"-o", "root", "cmd"})
+    if parser.GetStr("output") != "root" || cmdParser.GetStr("output") != "root" {
+        t.Fail()
+    }
+}
+
+
+func TestSharedStrOverride(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    parser.AddSharedStr([]string{"cmd"}, "output o", "default")
+    parser.ParseArgs([]string{"-o", "root", "cmd", "--output", "cmd"})
+    if parser.GetStr("output") != "root" || cmdParser.GetStr("output") != "cmd" {
+        t.Fail()
+    }
+}
+
+
+func TestSharedStrDefault(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    parser.AddSharedStr([]string{"cmd"}, "output o", "default")
+    parser.ParseArgs([]string{"cmd"})
+    if cmdParser.GetStr("output") != "default" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Config tables.
+// -------------------------------------------------------------------------
+
+
+func TestConfigTable(t *testing.T) {
+    t.Setenv("COLUMNS", "20")
+    parser := NewParser("", "")
+    parser.AddInt("int i", 1)
+    parser.AddStr("long-name", "default")
+    parser.AddStrList("list", false)
+    parser.ParseArgs([]string{"-i", "2", "--list", "a", "--list", "abcdefghijklmnop"})
+    expected := strings.Join([]string{
+        "--int        2",
+        "--list       a, a...",
+        "--long-name  default",
+    }, "\n")
+    if parser.ConfigTable() != expected {
+        t.Fail()
+    }
+}
+
+
+func TestConfigTableDefaultFrom(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("name", "app")
+    parser.AddStr("log", "")
+    parser.SetDefaultFrom("log", func(p *ArgParser) string {
+        return p.GetStr("name") + ".log"
+    })
+    parser.ParseArgs([]string{"--name", "server"})
+    if !strings.Contains(parser.ConfigTable(), "--log   server.log") {
+        t.Fail()
+    }
+}
+
+
+func TestConfigTableSharedFallback(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    parser.AddSharedStr([]string{"cmd"}, "output", "default")
+    parser.ParseArgs([]string{"--output", "root", "cmd"})
+    if cmdParser.ConfigTable() != "--output  root" {
+        t.Fail()
+    }
+}
+
+
+func TestConfigTableNonASCII(t *testing.T) {
+    t.Setenv("COLUMNS", "20")
+    parser := NewParser("", "")
+    parser.AddStr("größe", "abcdefghijklmnop")
+    parser.AddStr("xyz", "1")
+    expected := strings.Join([]string{
+        "--größe  abcdefgh...",
+        "--xyz    1",
+    }, "\n")
+    if parser.ConfigTable() != expected {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Flag list counts.
+// -------------------------------------------------------------------------
+
+
+func TestFlagListEqualsCount(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagList("verbose v")
+    parser.ParseArgs([]string{"-vv", "--verbose=5"})
+    if parser.LenList("verbose") != 5 {
+        t.Fail()
+    }
+}
+
+
+func TestFlagListEqualsThenIncrement(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagList("verbose v")
+    parser.ParseArgs([]string{"-v=2", "-v"})
+    if parser.LenList("verbose") != 3 {
+        t.Fail()
+    }
+}
+
+
+func TestFlagListEqualsInvalid(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagList("verbose v")
+    if tryParse(parser, []string{"--verbose=lots"}) == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Secrets.
+// -------------------------------------------------------------------------
+
+
+func TestMarkSecret(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("token", "default-token")
+    parser.MarkSecret("token")
+    parser.ParseArgs([]string{"--token", "hunter2"})
+    if parser.GetStr("token") != "hunter2" {
+        t.Fail()
+    }
+    outputs := []string{
+        parser.String(),
+        parser.ConfigTable(),
+        parser.HelpMarkdown(),
+        parser.GenerateManPage("app", "1"),
+        strings.Join(parser.Dump().Options[0].Values, ""),
+    }
+    for _, output := range outputs {
+        if strings.Contains(output, "hunter2") || strings.Contains(output, "default-token") {
+            t.Fail()
+        }
+        if !strings.Contains(output, "****") {
+            t.Fail()
+        }
+    }
+}
+
+
+func TestMarkSecretErrors(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrChoices("token", "alpha", []string{"alpha", "beta"})
+    parser.AddInt("pin", 0)
+    parser.AddStrList("keys", false)
+    parser.MarkSecret("token")
+    parser.MarkSecret("pin")
+    parser.MarkSecret("keys")
+    parser.SetStrListValidator("keys", func(s string) error {
+        return fmt.Errorf("rejected")
+    })
+    parser.SetEnv("pin", "CLIO_TEST_SECRET_PIN")
+    t.Setenv("CLIO_TEST_SECRET_PIN", "12x34")
+    args := [][]string{
+        []string{"--token", "hunter2"},
+        []string{"--keys", "hunter2"},
+        []string{},
+    }
+    for _, arg := range args {
+        err := tryParse(parser, arg)
+        if err == nil {
+            t.Fatalf("expected an error for %v", arg)
+        }
+        if strings.Contains(err.Error(), "hunter2") || strings.Contains(err.Error(), "12x34") {
+            t.Errorf("secret value leaked: %v", err)
+        }
+        if !strings.Contains(err.Error(), "****") {
+            t.Errorf("secret value not masked: %v", err)
+        }
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// List validators.
+// -------------------------------------------------------------------------
+
+
+func TestListValidator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntList("ports", true)
+    parser.SetListValidator("ports", func(i int) error {
+        if i < 1 || i > 65535 {
+            return fmt.Errorf("not a port number")
+        }
+        return nil
+    })
+    if tryParse(parser, []string{"--ports", "80", "443"}) != nil {
+        t.Fail()
+    }
+    err := tryParse(parser, []string{"--ports", "80", "70000"})
+    if err == nil || err.Error() != "'70000' is not a valid value for --ports: not a port number" {
+        t.Fail()
+    }
+}
+
+
+func TestStrListValidator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("names", false)
+    parser.SetStrListValidator("names", func(s string) error {
+        if s == "" {
+            return fmt.Errorf("empty name")
+        }
+        return nil
+    })
+    if tryParse(parser, []string{"--names", "foo"}) != nil {
+        t.Fail()
+    }
+    err := tryParse(parser, []string{"--names", ""})
+    if err == nil || !strings.Contains(err.Error(), "empty name") {
+        t.Fail()
+    }
+}
+
+
+func TestFloatListValidator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFloatList("ratios", false)
+    parser.SetFloatListValidator("ratios", func(f float64) error {
+        if f > 1.0 {
+            return fmt.Errorf("ratio out of range")
+        }
+        return nil
+    })
+    if tryParse(parser, []string{"--ratios", "1.5"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestListValidatorChained(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntList("ports", true)
+    parser.SetRange("ports", 1, 65535)
+    parser.SetListValidator("ports", func(i int) error {
+        if i % 2 != 0 {
+            return fmt.Errorf("must be even")
+        }
+        return nil
+    })
+    if tryParse(parser, []string{"--ports", "70000"}) == nil {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"--ports", "81"}) == nil {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"--ports", "80"}) != nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Paths.
+// -------------------------------------------------------------------------
+
+
+func TestGetPathRelative(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("file", "")
+    parser.SetPathBase("/etc/app")
+    parser.ParseArgs([]string{"--file", "data/x.txt"})
+    if parser.GetPath("file") != "/etc/app/data/x.txt" {
+        t.Fail()
+    }
+}
+
+
+func TestGetPathAbsolute(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("file", "")
+    parser.SetPathBase("/etc/app")
+    parser.ParseArgs([]string{"--file", "/tmp/x.txt"})
+    if parser.GetPath("file") != "/tmp/x.txt" {
+        t.Fail()
+    }
+}
+
+
+func TestGetPathNoBase(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("file", "")
+    parser.ParseArgs([]string{"--file", "x.txt"})
+    if parser.GetPath("file") != "x.txt" {
+        t.Fail()
+    }
+}
+
+
+func TestGetPathHome(t *testing.T) {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        t.Skip()
+    }
+    parser := NewParser("", "")
+    parser.AddStr("file", "")
+    parser.SetPathBase("/etc/app")
+    parser.ParseArgs([]string{"--file", "~/x.txt"})
+    if parser.GetPath("file") != home + "/x.txt" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Globbing.
+// -------------------------------------------------------------------------
+
+
+func TestGetArgsGlobbed(t *testing.T) {
+    dir := t.TempDir()
+    for _, name := range []string{"a.go", "b.go", "c.txt"} {
+        os.WriteFile(dir + "/" + name, []byte{}, 0644)
+    }
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{dir + "/*.go", dir + "/*.md", "literal"})
+    paths, err := parser.GetArgsGlobbed()
+    if err != nil || len(paths) != 4 {
+        t.Fail()
+        return
+    }
+    if paths[0] != dir + "/a.go" || paths[1] != dir + "/b.go" || paths[2] != dir + "/*.md" {
+        t.Fail()
+    }
+}
+
+
+func TestGetArgsGlobbedStrict(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetGlobStrict(true)
+    parser.ParseArgs([]string{t.TempDir() + "/*.md"})
+    if _, err := parser.GetArgsGlobbed(); err == nil {
+        t.Fail()
+    }
+}
+
+
+func TestGetArgsGlobbedBadPattern(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"[a"})
+    if _, err := parser.GetArgsGlobbed(); err == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// DOT graphs.
+// -------------------------------------------------------------------------
+
+
+func TestGenerateDOT(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose v")
+    buildParser := parser.AddCmd("build b", "", callback)
+    buildParser.AddCmd("docs", "", callback)
+    expected := strings.Join([]string{
+        `digraph "app" {`,
+        `    "app" [label="app\n1 option"];`,
+        `    "app" -> "app build";`,
+        `    "app build" [label="build\n0 options"];`,
+        `    "app build" -> "app build docs";`,
+        `    "app build docs" [label="docs\n0 options"];`,
+        `}`,
+        ``,
+    }, "\n")
+    if parser.GenerateDOT("app") != expected {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Computed defaults.
+// -------------------------------------------------------------------------
+
+
+func TestDefaultFrom(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("name", "app")
+    parser.AddStr("log-file", "")
+    parser.SetDefaultFrom("log-file", func(p *ArgParser) string {
+        return p.GetStr("name") + ".log"
+    })
+    parser.ParseArgs([]string{"--name", "server"})
+    if parser.GetStr("log-file") != "server.log" {
+        t.Fail()
+    }
+}
+
+
+func TestDefaultFromOverridden(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("name", "app")
+    parser.AddStr("log-file", "")
+    parser.SetDefaultFrom("log-file", func(p *ArgParser) string {
+        return p.GetStr("name") + ".log"
+    })
+    parser.ParseArgs([]string{"--log-file", "out.log"})
+    if parser.GetStr("log-file") != "out.log" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Configuration validation.
+// -------------------------------------------------------------------------
+
+
+func TestAddCmdNilCallback(t *testing.T) {
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", nil)
+}
+
+
+func TestValidateOK(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("tls")
+    parser.AddStr("cert", "")
+    parser.RequireIf("tls", "cert")
+    parser.AddCmd("cmd", "", callback)
+    if parser.Validate() != nil {
+        t.Fail()
+    }
+}
+
+
+func TestValidateProblems(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetCommandProvider(func(name string) (*ArgParser, func(*ArgParser), bool) {
+        switch name {
+        case "good":
+            return NewParser("", ""), callback, true
+        case "nocallback":
+            return NewParser("", ""), nil, true
+        }
+        return nil, nil, false
+    })
+    if parser.Validate("good") != nil {
+        t.Fail()
+    }
+    err := parser.Validate("good", "nocallback", "missing")
+    if err == nil {
+        t.Fatal("expected an error")
+    }
+    expected := "the provided command 'nocallback' has no callback; " +
+        "the command provider does not supply the command 'missing'"
+    if err.Error() != expected {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Run.
+// -------------------------------------------------------------------------
+
+
+func TestMissingCommand(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", callback)
+    parser.ParseArgs([]string{})
+    if !parser.missingCommand() {
+        t.Fail()
+    }
+}
+
+
+func TestMissingCommandFound(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", callback)
+    parser.ParseArgs([]string{"cmd"})
+    if parser.missingCommand() {
+        t.Fail()
+    }
+}
+
+
+func TestMissingCommandRootAction(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("cmd", "", callback)
+    parser.SetRootAction(callback)
+    parser.ParseArgs([]string{})
+    if parser.missingCommand() {
+        t.Fail()
+    }
+}
+
+
+func TestMissingCommandNoCommands(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{})
+    if parser.missingCommand() {
+        t.Fail()
+    }
+}
+
+
+// Runs parser.Run() with the specified command line arguments, returning
+// its error output and exit status.
+func runWithArgs(parser *ArgParser, args []string) (string, int) {
+    var errBuf strings.Builder
+    parser.SetErr(&errBuf)
+    oldArgs := os.Args
+    defer func() {
+        os.Args = oldArgs
+    }()
+    os.Args = append([]string{oldArgs[0]}, args...)
+    code := catchExit(func() {
+        parser.Run()
+    })
+    return errBuf.String(), code
+}
+
+
+func TestRunMissingCommand(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AddCmd("cmd", "", callback)
+    stderr, code := runWithArgs(parser, []string{})
+    expected := fmt.Sprintf(
+        "Error: a command is required.\nUsage: %v [options] [command]\n\n" +
+            "Flags:\n  --help  Print this help text and exit.\n\n" +
+            "Commands:\n  cmd\n",
+        progName(),
+    )
+    if code != 1 || stderr != expected {
+        t.Fatalf("got %v %q", code, stderr)
+    }
+}
+
+
+func TestRunWithCommand(t *testing.T) {
+    ran := false
+    parser := NewParser("Help!", "")
+    parser.AddCmd("cmd", "", func(p *ArgParser) {
+        ran = true
+    })
+    stderr, code := runWithArgs(parser, []string{"cmd"})
+    if code != -1 || stderr != "" || !ran {
+        t.Fatalf("got %v %q", code, stderr)
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Decimal integers.
+// -------------------------------------------------------------------------
+
+
+func TestIntImpliedBase(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("int", 0)
+    parser.ParseArgs([]string{"--int", "010"})
+    if parser.GetInt("int") != 8 {
+        t.Fail()
+    }
+}
+
+
+func TestDecimalIntsOnly(t *testing.T) {
+    parser := NewParser("", "")
+    parser.DecimalIntsOnly()
+    cmdParser := parser.AddCmd("cmd", "", callback)
+    cmdParser.AddInt("int", 0)
+    parser.ParseArgs([]string{"cmd", "--int", "010", "020"})
+    if cmdParser.GetInt("int") != 10 || cmdParser.GetArgsAsInts()[0] != 20 {
+        t.Fail()
+    }
+}
+
+
+func TestDecimalIntsOnlyError(t *testing.T) {
+    parser := NewParser("", "")
+    parser.DecimalIntsOnly()
+    parser.AddInt("int", 0)
+    err := tryParse(parser, []string{"--int", "0x10"})
+    if err == nil || !strings.Contains(err.Error(), "expected a decimal integer") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Splitting arguments.
+// -------------------------------------------------------------------------
+
+
+func TestSplitArgsAt(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"a", "b", "to", "c", "to", "d"})
+    before, after := parser.SplitArgsAt("to")
+    if strings.Join(before, " ") != "a b" || strings.Join(after, " ") != "c to d" {
+        t.Fail()
+    }
+}
+
+
+func TestSplitArgsAtMissing(t *testing.T) {
+    parser := NewParser("", "")
+    parser.ParseArgs([]string{"a", "b"})
+    before, after := parser.SplitArgsAt("to")
+    if len(before) != 2 || after != nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Error format.
+// -------------------------------------------------------------------------
+
+
+func TestParseErrorOptionMissingArg(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("name n", "default")
+    err := tryParse(parser, []string{"--name"})
+    if err == nil || err.(*ParseError).Option != "--name" {
+        t.Fail()
+    }
+}
+
+
+func TestParseErrorOptionInvalidValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("count c", 0)
+    err := tryParse(parser, []string{"-c", "foo"})
+    if err == nil || err.(*ParseError).Option != "--count" {
+        t.Fail()
+    }
+}
+
+
+func TestParseErrorOptionUnknown(t *testing.T) {
+    parser := NewParser("", "")
+    err := tryParse(parser, []string{"--foo=bar"})
+    if err == nil || err.(*ParseError).Option != "--foo" {
+        t.Fail()
+    }
+}
+
+
+func TestParseErrorOptionEmpty(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AddCmd("boo", "", callback)
+    err := tryParse(parser, []string{"help", "foo"})
+    if err == nil || err.(*ParseError).Option != "" {
+        t.Fail()
+    }
+}
+
+
+func TestErrorFormatInherited(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("boo", "", callback)
+    if cmdParser.getErrorFormat() != ErrorFormatText {
+        t.Fail()
+    }
+    parser.SetErrorFormat(ErrorFormatJSON)
+    if cmdParser.getErrorFormat() != ErrorFormatJSON {
+        t.Fail()
+    }
+}
+
+
+func TestErrorFormatJSON(t *testing.T) {
+    stdout, stderr, code := Capture(func(parser *ArgParser) {
+        parser.SetErrorFormat(ErrorFormatJSON)
+        cmdParser := parser.AddCmd("boo", "Boo!", callback)
+        cmdParser.AddInt("count c", 0)
+    }, []string{"boo", "-c", "foo"})
+    var output struct {
+        Error string
+        Option string
+    }
+    if err := json.Unmarshal([]byte(stderr), &output); err != nil {
+        t.Fatalf("got %q: %v", stderr, err)
+    }
+    if output.Error != "cannot parse 'foo' as an integer" || output.Option != "--count" {
+        t.Fail()
+    }
+    if stdout != "" || code != 1 || strings.Count(stderr, "\n") != 1 {
+        t.Fail()
+    }
+}
+
+
+func TestErrorFormatJSONPositionalInt(t *testing.T) {
+    var errBuf strings.Builder
+    parser := NewParser("", "")
+    parser.SetErrorFormat(ErrorFormatJSON)
+    parser.SetErr(&errBuf)
+    parser.ParseArgs([]string{"abc"})
+    code := catchExit(func() {
+        parser.GetArgsAsInts()
+    })
+    var output struct {
+        Error string
+    }
+    if err := json.Unmarshal([]byte(errBuf.String()), &output); err != nil {
+        t.Fatalf("got %q: %v", errBuf.String(), err)
+    }
+    if code != 1 || output.Error != "cannot parse 'abc' as an integer" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Clearing secrets.
+// -------------------------------------------------------------------------
+
+
+func TestGetSecretAndClear(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("pass p", "")
+    parser.MarkSecret("pass")
+    parser.ParseArgs([]string{"--pass", "hunter2"})
+    if parser.GetStr("pass") != "hunter2" {
+        t.Fail()
+    }
+    stored := parser.options["pass"].values[1].secretVal
+    if parser.GetSecretAndClear("pass") != "hunter2" {
+        t.Fail()
+    }
+    if parser.GetStr("pass") != "" {
+        t.Fail()
+    }
+    for _, b := range stored {
+        if b != 0 {
+            t.Fail()
+        }
+    }
+}
+
+
+func TestGetSecretAndClearNotSecret(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("pass p", "")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.GetSecretAndClear("pass")
+}
+
+
+// -------------------------------------------------------------------------
+// Command suggestions.
+// -------------------------------------------------------------------------
+
+
+func TestCommandSuggestionDefault(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("build", "", callback)
+    parser.AddCmd("clean", "", callback)
+    err := parser.ParseCommand("biuld", []string{})
+    if err == nil || !strings.HasSuffix(err.Error(), "did you mean: build") {
+        t.Fail()
+    }
+}
+
+
+func TestCommandSuggestionNone(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("build", "", callback)
+    err := parser.ParseCommand("xyzzy", []string{})
+    if err == nil || strings.Contains(err.Error(), "did you mean") {
+        t.Fail()
+    }
+}
+
+
+func TestCommandSuggestionCustom(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AddCmd("build", "", callback)
+    parser.SetCommandSuggester(func(input string, known []string) []string {
+        return []string{"plugin-" + input}
+    })
+    err := tryParse(parser, []string{"help", "foo"})
+    if err == nil || !strings.HasSuffix(err.Error(), "did you mean: plugin-foo") {
+        t.Fail()
+    }
+}
+
+
+func TestEditDistance(t *testing.T) {
+    if editDistance("kitten", "sitting") != 3 || editDistance("", "abc") != 3 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Parse duration.
+// -------------------------------------------------------------------------
+
+
+func TestLastParseDurationZero(t *testing.T) {
+    parser := NewParser("", "")
+    if parser.LastParseDuration() != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestLastParseDurationExcludesCallback(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("boo", "", func(p *ArgParser) {
+        time.Sleep(50 * time.Millisecond)
+    })
+    parser.ParseArgs([]string{"boo"})
+    duration := parser.LastParseDuration()
+    if duration <= 0 || duration >= 50 * time.Millisecond {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Required options.
+// -------------------------------------------------------------------------
+
+
+func TestRequireMissing(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("config c", "")
+    parser.Require("config")
+    err := tryParse(parser, []string{})
+    if err == nil || err.Error() != "the --config option is required" {
+        t.Fail()
+    }
+}
+
+
+func TestRequirePresent(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("config c", "")
+    parser.Require("config")
+    if tryParse(parser, []string{"-c", "app.ini"}) != nil {
+        t.Fail()
+    }
+}
+
+
+func TestRequireFromEnv(t *testing.T) {
+    t.Setenv("CLIO_TEST_REQUIRE", "app.ini")
+    parser := NewParser("", "")
+    parser.AddStr("config c", "")
+    parser.SetEnv("config", "CLIO_TEST_REQUIRE")
+    parser.Require("config")
+    if tryParse(parser, []string{}) != nil {
+        t.Fail()
+    }
+}
+
+
+func TestRequireSkippedForHelp(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.SetAutoExit(false)
+    parser.AddStr("config c", "")
+    parser.Require("config")
+    if tryParse(parser, []string{"--help"}) != nil || !parser.HelpRequested() {
+        t.Fail()
+    }
+}
+
+
+func TestRequireBeforeCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("boo", "", func(p *ArgParser) {
+        ran = true
+    })
+    cmdParser.AddStr("config c", "")
+    cmdParser.Require("config")
+    failed, err := tryParseFailed(parser, []string{"boo"})
+    if err == nil || ran {
+        t.Fail()
+    }
+    if failed != cmdParser {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"boo", "-c", "app.ini"}) != nil || !ran {
+        t.Fail()
+    }
+}
+
+
+func TestRequireOnParentBeforeCallback(t *testing.T) {
+    ran := false
+    parser := NewParser("", "")
+    parser.AddStr("must", "")
+    parser.Require("must")
+    cmdParser := parser.AddCmd("run", "", callback)
+    cmdParser.AddCmd("sub", "", func(p *ArgParser) {
+        ran = true
+    })
+    err := tryParse(parser, []string{"run"})
+    if err == nil || err.Error() != "the --must option is required" {
+        t.Fail()
+    }
+    failed, err := tryParseFailed(parser, []string{"run", "sub"})
+    if err == nil || ran || failed != parser {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"--must", "x", "run", "sub"}) != nil || !ran {
+        t.Fail()
+    }
+}
+
+
+func TestRequireOnCommand(t *testing.T) {
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("boo", "", callback)
+    cmdParser.AddStr("config c", "")
+    cmdParser.Require("config")
+    if tryParse(parser, []string{}) != nil {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"boo"}) == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Bound variables.
+// -------------------------------------------------------------------------
+
+
+func TestBoundVarsDefaults(t *testing.T) {
+    var b bool
+    var str string
+    var i int
+    var f float64
+    parser := NewParser("", "")
+    parser.BoolVar(&b, "bool b")
+    parser.StrVar(&str, "string s", "default")
+    parser.IntVar(&i, "int i", 101)
+    parser.FloatVar(&f, "float f", 1.5)
+    if b || str != "default" || i != 101 || f != 1.5 {
+        t.Fail()
+    }
+    parser.ParseArgs([]string{})
+    if b || str != "default" || i != 101 || f != 1.5 {
+        t.Fail()
+    }
+}
+
+
+func TestBoundVarsParsed(t *testing.T) {
+    var b bool
+    var str string
+    var i int
+    var f float64
+    parser := NewParser("", "")
+    parser.BoolVar(&b, "bool b")
+    parser.StrVar(&str, "string s", "default")
+    parser.IntVar(&i, "int i", 101)
+    parser.FloatVar(&f, "float f", 1.5)
+    parser.ParseArgs([]string{"-b", "--string", "foo", "-i", "202", "--float", "2.5"})
+    if !b || str != "foo" || i != 202 || f != 2.5 {
+        t.Fail()
+    }
+}
+
+
+func TestBoundVarsSetBeforeCallback(t *testing.T) {
+    var str string
+    var seen string
+    parser := NewParser("", "")
+    cmdParser := parser.AddCmd("boo", "", func(p *ArgParser) {
+        seen = str
+    })
+    cmdParser.StrVar(&str, "string s", "default")
+    parser.ParseArgs([]string{"boo", "-s", "foo"})
+    if seen != "foo" {
+        t.Fail()
+    }
+}
+
+
+func TestParentBoundVarsSetBeforeCallback(t *testing.T) {
+    var str string
+    var num int
+    var seenStr string
+    var seenNum int
+    parser := NewParser("", "")
+    parser.StrVar(&str, "string s", "default")
+    parser.IntVar(&num, "num", 0)
+    parser.AddCmd("boo", "", func(p *ArgParser) {
+        seenStr, seenNum = str, num
+    })
+    parser.ParseArgs([]string{"-s", "foo", "--num", "3", "boo"})
+    if seenStr != "foo" || seenNum != 3 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Help epilog.
+// -------------------------------------------------------------------------
+
+
+func TestEpilogInHelpText(t *testing.T) {
+    parser := NewParser("Usage: app", "")
+    parser.SetEpilog("  Example: app foo  ")
+    if parser.GetHelpText() != "Usage: app\n\nExample: app foo" {
+        t.Fail()
+    }
+}
+
+
+func TestEpilogWithoutHelpText(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetEpilog("Example: app foo")
+    if parser.GetHelpText() != "Example: app foo" {
+        t.Fail()
+    }
+    err := tryParse(parser, []string{"--help"})
+    if err == nil {
+        t.Fail()
+    }
+}
+
+
+func TestEpilogInMarkdown(t *testing.T) {
+    parser := NewParser("Usage: app", "")
+    parser.AddCmd("boo", "", callback)
+    parser.SetEpilog("Example: app foo")
+    markdown := parser.HelpMarkdown()
+    if strings.Index(markdown, "Example: app foo") < strings.Index(markdown, "* `boo`") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// String choices.
+// -------------------------------------------------------------------------
+
+
+func TestStrChoicesValid(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrChoices("format f", "json", []string{"json", "yaml", "xml"})
+    parser.ParseArgs([]string{"--format", "yaml"})
+    if parser.GetStr("format") != "yaml" {
+        t.Fail()
+    }
+}
+
+
+func TestStrChoicesInvalid(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrChoices("format f", "json", []string{"json", "yaml", "xml"})
+    err := tryParse(parser, []string{"--format", "toml"})
+    expected := "'toml' is not a valid value for --format (choose from json, yaml, xml)"
+    if err == nil || err.Error() != expected {
+        t.Fail()
+    }
+}
+
+
+func TestStrChoicesInvalidDefault(t *testing.T) {
+    parser := NewParser("", "")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.AddStrChoices("format f", "toml", []string{"json", "yaml", "xml"})
+}
+
+
+func TestStrChoicesInMarkdown(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrChoices("format f", "json", []string{"json", "yaml"})
+    if !strings.Contains(parser.HelpMarkdown(), "str (choose from json, yaml)") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Windows command lines.
+// -------------------------------------------------------------------------
+
+
+func TestParseWindowsCommandLine(t *testing.T) {
+    cases := map[string][]string{
+        `"abc" d e`: {`abc`, `d`, `e`},
+        `a\\\b d"e f"g h`: {`a\\\b`, `de fg`, `h`},
+        `a\\\"b c d`: {`a\"b`, `c`, `d`},
+        `a\\\\"b c" d e`: {`a\\b c`, `d`, `e`},
+        `a"b"" c d`: {`ab" c d`},
+        "  foo\t\"\"  ": {`foo`, ``},
+    }
+    for line, expected := range cases {
+        args := ParseWindowsCommandLine(line)
+        if fmt.Sprintf("%q", args) != fmt.Sprintf("%q", expected) {
+            t.Errorf("%v: got %q", line, args)
+        }
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// String maps.
+// -------------------------------------------------------------------------
+
+
+func TestMapEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddMap("define D")
+    parser.ParseArgs([]string{})
+    keys, values := parser.GetMapOrdered("define")
+    if len(keys) != 0 || len(values) != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestMapOrdered(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddMap("define D")
+    parser.ParseArgs([]string{"-D", "Y=2", "--define", "X=a=b", "-D", "Y=3", "-D", "Z="})
+    keys, values := parser.GetMapOrdered("define")
+    if strings.Join(keys, ",") != "Y,X,Z" {
+        t.Fail()
+    }
+    if values["Y"] != "3" || values["X"] != "a=b" || values["Z"] != "" {
+        t.Fail()
+    }
+    if len(parser.GetMap("define")) != 3 {
+        t.Fail()
+    }
+}
+
+
+func TestMapInvalidPair(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddMap("define D")
+    if tryParse(parser, []string{"-D", "=foo"}) == nil {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"-D", "foo"}) == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Generated help text.
+// -------------------------------------------------------------------------
+
+
+func TestBuildHelp(t *testing.T) {
+    parser := NewParser("", "1.0")
+    parser.AddFlag("verbose v").Desc("Print more output.")
+    parser.AddStr("output o", "out.txt").Desc("Output file.")
+    parser.AddInt("count", 0)
+    parser.AddCmd("boo bar", "", callback)
+    expected := strings.Join([]string{
+        "Usage: " + progName() + " [options] [command]",
+        "",
+        "Flags:",
+        "  -v, --verbose       Print more output.",
+        "  --help              Print this help text and exit.",
+        "  --version           Print the version number and exit.",
+        "",
+        "Options:",
+        "  --count <int>       (default: 0)",
+        "  -o, --output <str>  Output file. (default: out.txt)",
+        "",
+        "Commands:",
+        "  boo, bar",
+    }, "\n")
+    if parser.BuildHelp() != expected {
+        t.Fail()
+    }
+}
+
+
+func TestBuildHelpIsFallback(t *testing.T) {
+    parser := NewParser("", "")
+    parser.SetAutoExit(false)
+    parser.AddFlag("verbose v").Desc("Print more output.")
+    if tryParse(parser, []string{"--help"}) != nil || !parser.HelpRequested() {
+        t.Fail()
+    }
+    if parser.fullHelpText() != parser.BuildHelp() {
+        t.Fail()
+    }
+}
+
+
+func TestHelpInactiveWithoutHelpText(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose v")
+    if tryParse(parser, []string{"--help"}) == nil {
+        t.Fail()
+    }
+    if strings.Contains(parser.BuildHelp(), "--help") {
+        t.Fail()
+    }
+}
+
+
+func TestBuildHelpNotUsedWithHelpText(t *testing.T) {
+    parser := NewParser("Help!", "")
+    if parser.GetHelpText() != "Help!" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Option descriptions and metavars.
+// -------------------------------------------------------------------------
+
+
+func TestOptionDescMetavar(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("out o", "-").Desc("Output file.").Metavar("FILE")
+    parser.AddFlag("quiet q").Desc("Suppress output.")
+    opt := parser.options["out"]
+    if opt.desc != "Output file." || opt.metavar != "FILE" {
+        t.Fail()
+    }
+    help := parser.BuildHelp()
+    if !strings.Contains(help, "-o, --out FILE  Output file. (default: -)") {
+        t.Fail()
+    }
+    if !strings.Contains(help, "-q, --quiet     Suppress output.") {
+        t.Fail()
+    }
+}
+
+
+func TestOptionBuilderOnBoundVar(t *testing.T) {
+    var count int
+    parser := NewParser("", "")
+    parser.IntVar(&count, "count c", 1).Desc("Repetitions.")
+    if parser.options["c"].desc != "Repetitions." {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Argument roles.
+// -------------------------------------------------------------------------
+
+
+func formatRoles(roles []ArgRole) string {
+    parts := make([]string, 0, len(roles))
+    for _, role := range roles {
+        parts = append(parts, fmt.Sprintf("%v:%v:%v", role.Arg, role.Role, role.Option))
+    }
+    return strings.Join(parts, " ")
+}
+
+
+func TestArgRoles(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose v")
+    parser.AddStr("out o", "")
+    parser.AddIntList("nums n", true)
+    cmdParser := parser.AddCmd("boo", "", callback)
+    cmdParser.AddStr("name", "")
+    parser.ParseArgs([]string{
+        "-vo", "x", "--nums", "1", "2", "--out=y", "boo", "--name", "", "a", "--", "-b",
+    })
+    expected := "-vo:option:vo x:value:out --nums:option:nums 1:value:nums 2:value:nums " +
+        "--out=y:option:out boo:command: --name:option:name :value:name a:positional: " +
+        "--:terminator: -b:positional:"
+    if formatRoles(parser.ArgRoles()) != expected {
+        t.Fail()
+    }
+}
+
+
+func TestArgRolesUnparsed(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.SetAutoExit(false)
+    parser.ParseArgs([]string{"a", "--help", "b"})
+    expected := "a:positional: --help:option:help b:unparsed:"
+    if formatRoles(parser.ArgRoles()) != expected {
+        t.Fail()
+    }
+}
+
+
+func TestArgRolesBeforeParse(t *testing.T) {
+    parser := NewParser("", "")
+    if parser.ArgRoles() != nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Negatable flags.
+// -------------------------------------------------------------------------
+
+
+func TestNegatableFlagDefault(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagNegatable("verbose v", true)
+    parser.ParseArgs([]string{})
+    if !parser.GetFlag("verbose") || parser.Found("verbose") {
+        t.Fail()
+    }
+}
+
+
+func TestNegatableFlagLastWins(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagNegatable("verbose v", false)
+    parser.ParseArgs([]string{"-v", "--no-verbose"})
+    if parser.GetFlag("verbose") || !parser.Found("verbose") {
+        t.Fail()
+    }
+    parser = NewParser("", "")
+    parser.AddFlagNegatable("verbose v", false)
+    parser.ParseArgs([]string{"--no-verbose", "--verbose"})
+    if !parser.GetFlag("verbose") {
+        t.Fail()
+    }
+}
+
+
+func TestNegatableFlagShortFormNotNegated(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagNegatable("verbose v", false)
+    if tryParse(parser, []string{"--no-v"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestNegationRequiresNegatable(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose")
+    if tryParse(parser, []string{"--no-verbose"}) == nil {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Counters.
+// -------------------------------------------------------------------------
+
+
+func TestCounterAbsent(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCounter("verbose v")
+    parser.ParseArgs([]string{})
+    if parser.GetCount("verbose") != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestCounterCondensedAndRepeated(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCounter("verbose v")
+    parser.AddFlag("quiet q")
+    parser.ParseArgs([]string{"-vqv", "--verbose", "-v"})
+    if parser.GetCount("verbose") != 4 || !parser.GetFlag("quiet") {
+        t.Fail()
+    }
+}
+
+
+func TestCounterExplicitValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCounter("verbose v")
+    parser.ParseArgs([]string{"-vv", "--verbose=5"})
+    if parser.GetCount("v") != 5 {
+        t.Fail()
+    }
+}
+
+
+func TestCounterWrongType(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose v")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.GetCount("verbose")
+}
+
+
+// -------------------------------------------------------------------------
+// Ranges and struct binding.
+// -------------------------------------------------------------------------
+
+
+func TestRangeInt(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("port p", 8080)
+    parser.SetRange("port", 1, 65535)
+    err := tryParse(parser, []string{"-p", "0"})
+    expected := "'0' is not a valid value for --port: must be between 1 and 65535"
+    if err == nil || err.Error() != expected {
+        t.Fail()
+    }
+    if tryParse(parser, []string{"-p", "65535"}) != nil {
+        t.Fail()
+    }
+}
+
+
+func TestRangeOpenBound(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFloatList("ratio r", false)
+    parser.SetRange("ratio", 0, math.Inf(1))
+    err := tryParse(parser, []string{"-r", "1.5", "-r", "-0.5"})
+    if err == nil || !strings.HasSuffix(err.Error(), "must be at least 0") {
+        t.Fail()
+    }
+}
+
+
+type bindConfig struct {
+    Port int `clio:"port p" default:"8080" min:"1" max:"65535"`
+    Ratio float64 `clio:"ratio" default:"0.5"`
+    Name string `clio:"name n" default:"anon"`
+    Verbose bool `clio:"verbose v"`
+    Tags []string `clio:"tag t"`
+    Other string
+}
+
+
+func TestBind(t *testing.T) {
+    var config bindConfig
+    parser := NewParser("", "")
+    if parser.Bind(&config) != nil {
+        t.Fail()
+    }
+    if config.Port != 8080 || config.Ratio != 0.5 || config.Name != "anon" {
+        t.Fail()
+    }
+    parser.ParseArgs([]string{"-p", "80", "-v", "-t", "a", "-t", "b"})
+    if config.Port != 80 || !config.Verbose || strings.Join(config.Tags, ",") != "a,b" {
+        t.Fail()
+    }
+    if parser.NumOptions() != 5 {
+        t.Fail()
+    }
+}
+
+
+func TestBindRange(t *testing.T) {
+    var config bindConfig
+    parser := NewParser("", "")
+    parser.Bind(&config)
+    if tryParse(parser, []string{"--port", "70000"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestBindErrors(t *testing.T) {
+    var badDefault struct {
+        Port int `clio:"port" default:"80x"`
+    }
+    var badType struct {
+        Limit uint `clio:"limit"`
+    }
+    var badRange struct {
+        Name string `clio:"name" min:"1"`
+    }
+    var badBounds struct {
+        Port int `clio:"port" min:"10" max:"1"`
+    }
+    for _, ptr := range []interface{}{&badDefault, &badType, &badRange, &badBounds} {
+        parser := NewParser("", "")
+        err := parser.Bind(ptr)
+        if err == nil || !strings.HasPrefix(err.Error(), "clio: field '") || parser.NumOptions() != 0 {
+            t.Errorf("%T: %v", ptr, err)
+        }
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Debugging arguments.
+// -------------------------------------------------------------------------
+
+
+func TestDebugArgsText(t *testing.T) {
+    ran := false
+    parser := NewParser("Help!", "")
+    parser.EnableDebugArgs()
+    parser.AddStr("out o", "")
+    cmdParser := parser.AddCmd("boo", "Boo!", func(p *ArgParser) {
+        ran = true
+    })
+    cmdParser.AddFlag("force")
+    text := parser.debugArgsText([]string{"-o", "x", "boo", "--debug-args", "--help", "--bad"})
+    expected := strings.Join([]string{
+        "Arguments:",
+        "   0  -o            option o",
+        "   1  x             value for out",
+        "   2  boo           command",
+        "   3  --debug-args  option debug-args",
+        "   4  --help        option help",
+        "   5  --bad         unparsed",
+        "",
+    }, "\n")
+    if text != expected || ran {
+        t.Fail()
+    }
+}
+
+
+func TestDebugArgsTextError(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnableDebugArgs()
+    parser.AddInt("num n", 0)
+    text := parser.debugArgsText([]string{"--debug-args", "--bad"})
+    if !strings.HasSuffix(text, "Error: --bad is not a recognised option.\n") {
+        t.Fail()
+    }
+}
+
+
+func TestDebugArgsTextMasksSecrets(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnableDebugArgs()
+    parser.AddStr("password p", "")
+    parser.MarkSecret("password")
+    text := parser.debugArgsText([]string{"--password", "hunter2", "-p=hunter3", "--debug-args"})
+    if strings.Contains(text, "hunter") {
+        t.Fail()
+    }
+    if !strings.Contains(text, "  ****          value for password") ||
+        !strings.Contains(text, "  -p=****       option p") {
+        t.Fail()
+    }
+}
+
+
+func TestRequestsDebugArgs(t *testing.T) {
+    if !requestsDebugArgs([]string{"a", "--debug-args"}) {
+        t.Fail()
+    }
+    if requestsDebugArgs([]string{"--", "--debug-args"}) {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Environment-backed options.
+// -------------------------------------------------------------------------
+
+
+func TestEnvOptionsFallback(t *testing.T) {
+    t.Setenv("CLIO_TEST_STR", "foo")
+    t.Setenv("CLIO_TEST_INT", "12")
+    t.Setenv("CLIO_TEST_FLOAT", "1.5")
+    t.Setenv("CLIO_TEST_FLAG", "yes")
+    parser := NewParser("", "")
+    parser.AddStrEnv("str", "default", "CLIO_TEST_STR")
+    parser.AddIntEnv("int", 0, "CLIO_TEST_INT")
+    parser.AddFloatEnv("float", 0, "CLIO_TEST_FLOAT")
+    parser.AddFlagEnv("flag", "CLIO_TEST_FLAG")
+    parser.ParseArgs([]string{"--int", "34"})
+    if parser.GetStr("str") != "foo" || parser.GetInt("int") != 34 {
+        t.Fail()
+    }
+    if parser.GetFloat("float") != 1.5 || !parser.GetFlag("flag") {
+        t.Fail()
+    }
+}
+
+
+func TestEnvOptionsInvalidValue(t *testing.T) {
+    t.Setenv("CLIO_TEST_INT", "twelve")
+    parser := NewParser("", "")
+    parser.AddIntEnv("int", 0, "CLIO_TEST_INT")
+    err := tryParse(parser, []string{})
+    if err == nil || !strings.HasSuffix(err.Error(), "(from $CLIO_TEST_INT)") {
+        t.Fail()
+    }
+}
+
+
+func TestEnvOptionsNotFound(t *testing.T) {
+    t.Setenv("CLIO_TEST_STR", "foo")
+    t.Setenv("CLIO_TEST_FLAG", "yes")
+    parser := NewParser("", "")
+    parser.AddStrEnv("str", "default", "CLIO_TEST_STR")
+    parser.AddFlagEnv("flag", "CLIO_TEST_FLAG")
+    parser.AddStr("cert", "")
+    parser.RequireIf("flag", "cert")
+    parser.RequireUnless("str", "cert")
+    if err := tryParse(parser, []string{}); err != nil {
+        t.Fatal(err)
+    }
+    if parser.Found("str") || parser.Found("flag") || parser.SourceOf("str") != SourceEnv {
+        t.Fail()
+    }
+    if parser.GetStr("str") != "foo" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Typed defaults.
+// -------------------------------------------------------------------------
+
+
+func TestLoadDefaults(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("str", "default")
+    parser.AddInt("int", 0)
+    parser.AddFloat("float", 0)
+    parser.AddFlag("flag")
+    parser.AddStrList("list", false)
+    err := parser.LoadDefaults(map[string]interface{}{
+        "str": "foo",
+        "int": float64(2),
+        "float": 1.5,
+        "flag": true,
+        "list": []interface{}{"a", "b"},
+        "unknown": 123,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    parser.ParseArgs([]string{"--int", "1"})
+    if parser.GetStr("str") != "foo" || parser.GetInt("int") != 1 {
+        t.Fail()
+    }
+    if parser.GetFloat("float") != 1.5 || !parser.GetFlag("flag") {
+        t.Fail()
+    }
+    if len(parser.GetStrList("list")) != 2 || parser.SourceOf("str") != SourceConfig {
+        t.Fail()
+    }
+}
+
+
+func TestLoadDefaultsTypeMismatch(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("int", 0)
+    parser.AddStr("str", "")
+    if parser.LoadDefaults(map[string]interface{}{"int": "12"}) == nil {
+        t.Fail()
+    }
+    if parser.LoadDefaults(map[string]interface{}{"int": 1.5, "str": "foo"}) == nil {
+        t.Fail()
+    }
+    parser.ParseArgs([]string{})
+    if parser.GetInt("int") != 0 || parser.GetStr("str") != "" {
+        t.Fail()
+    }
+}
+
+
+func TestLoadDefaultsAfterParsing(t *testing.T) {
+    var port int
+    parser := NewParser("", "")
+    parser.IntVar(&port, "port", 80)
+    parser.AddStr("host", "localhost")
+    parser.ParseArgs([]string{"--host", "example.com"})
+    err := parser.LoadDefaults(map[string]interface{}{
+        "port": float64(8080),
+        "host": "config.com",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if parser.GetInt("port") != 8080 || port != 8080 || parser.SourceOf("port") != SourceConfig {
+        t.Fail()
+    }
+    if parser.GetStr("host") != "example.com" {
+        t.Fail()
+    }
+}
+
+
+func TestLoadDefaultsBeforeParsing(t *testing.T) {
+    var name string
+    parser := NewParser("", "")
+    parser.StrVar(&name, "name", "default")
+    parser.AddInt("num", 0)
+    parser.Require("name")
+    parser.SetSourceOrder(SourceConfig, SourceCLI, SourceDefault)
+    err := parser.LoadDefaults(map[string]interface{}{
+        "name": "config",
+        "num": float64(2),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := tryParse(parser, []string{"--num", "1"}); err != nil {
+        t.Fatal(err)
+    }
+    if name != "config" || parser.GetInt("num") != 2 {
+        t.Fail()
+    }
+    if parser.SourceOf("num") != SourceConfig {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Value aliases.
+// -------------------------------------------------------------------------
+
+
+func TestValueAliasLong(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("log-level", "info")
+    parser.AddValueAlias("verbose", "log-level", "debug")
+    parser.ParseArgs([]string{"--verbose"})
+    if parser.GetStr("log-level") != "debug" || !parser.Found("log-level") {
+        t.Fail()
+    }
+}
+
+
+func TestValueAliasShortCondensed(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("a")
+    parser.AddInt("level", 1)
+    parser.AddValueAlias("q", "level", "0")
+    parser.ParseArgs([]string{"-aq"})
+    if !parser.GetFlag("a") || parser.GetInt("level") != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestValueAliasInvalidValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddInt("level", 1)
+    parser.AddValueAlias("max", "level", "high")
+    if tryParse(parser, []string{"--max"}) == nil {
+        t.Fail()
+    }
+}
+
+
+func TestValueAliasNameCollision(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("verbose")
+    parser.AddStr("log-level", "info")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.AddValueAlias("verbose", "log-level", "debug")
+}
+
+
+func TestValueAliasHelp(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AddStr("log-level", "info")
+    parser.AddValueAlias("verbose", "log-level", "debug")
+    if !strings.Contains(parser.BuildHelp(), "  --verbose          Same as --log-level debug.") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Pairs options.
+// -------------------------------------------------------------------------
+
+
+func TestPairsRepeatedKeys(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddPairs("header H", ":")
+    parser.ParseArgs([]string{"-H", "A:1", "-H", "B:", "--header", "A:2:3"})
+    pairs := parser.GetPairs("header")
+    expected := [][2]string{{"A", "1"}, {"B", ""}, {"A", "2:3"}}
+    if len(pairs) != len(expected) {
+        t.Fatalf("got %v", pairs)
+    }
+    for i := range expected {
+        if pairs[i] != expected[i] {
+            t.Fatalf("got %v", pairs)
+        }
+    }
+}
+
+
+func TestPairsMissingSeparator(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddPairs("header H", ":")
+    err := tryParse(parser, []string{"-H", "A=1"})
+    if err == nil || !strings.Contains(err.Error(), "key:value") {
+        t.Fail()
+    }
+}
+
+
+func TestPairsEmpty(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddPairs("header H", ":")
+    parser.ParseArgs([]string{})
+    if pairs := parser.GetPairs("header"); pairs == nil || len(pairs) != 0 {
+        t.Fail()
+    }
+}
+
+
+func TestPairsEmptySeparator(t *testing.T) {
+    parser := NewParser("", "")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.AddPairs("header H", "")
+}
+
+
+// -------------------------------------------------------------------------
+// Passthrough mode.
+// -------------------------------------------------------------------------
+
+
+func TestPassthroughCollectsUnknown(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("a")
+    parser.AddStr("name", "")
+    parser.EnablePassthrough()
+    parser.ParseArgs([]string{"--foo", "bar", "-ax", "--name", "n", "--baz=1"})
+    unknown := parser.GetUnknown()
+    if strings.Join(unknown, " ") != "--foo -x --baz=1" {
+        t.Fatalf("got %v", unknown)
+    }
+    if !parser.GetFlag("a") || parser.GetStr("name") != "n" {
+        t.Fail()
+    }
+    if len(parser.GetArgs()) != 1 || parser.GetArgs()[0] != "bar" {
+        t.Fail()
+    }
+}
+
+
+func TestPassthroughNoUnknown(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnablePassthrough()
+    parser.ParseArgs([]string{"foo"})
+    if len(parser.GetUnknown()) != 0 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Terminal flags.
+// -------------------------------------------------------------------------
+
+
+func TestTerminalFlagRejectsLaterOption(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.AddFlag("verbose v")
+    parser.SetTerminalFlag("raw")
+    err := tryParse(parser, []string{"--raw", "foo", "-v"})
+    if err == nil || err.Error() != "no options allowed after --raw" {
+        t.Fail()
+    }
+}
+
+
+func TestTerminalFlagCondensed(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.AddFlag("verbose v")
+    parser.SetTerminalFlag("raw")
+    err := tryParse(parser, []string{"-rv"})
+    if err == nil || err.Error() != "no options allowed after --raw" {
+        t.Fail()
+    }
+    parser = NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.AddFlag("verbose v")
+    parser.SetTerminalFlag("raw")
+    if tryParse(parser, []string{"-vr", "foo"}) != nil {
+        t.Fail()
+    }
+}
+
+
+func TestTerminalFlagRepeated(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.SetTerminalFlag("raw")
+    if err := tryParse(parser, []string{"--raw", "foo", "--raw", "-r", "-rr"}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+
+func TestTerminalFlagAllowsEarlierOptions(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.AddFlag("verbose v")
+    parser.SetTerminalFlag("raw")
+    parser.ParseArgs([]string{"-v", "-r", "foo", "--", "-v"})
+    if !parser.GetFlag("raw") || len(parser.GetArgs()) != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestTerminalFlagAlias(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("raw r")
+    parser.AddFlag("verbose v")
+    parser.SetTerminalFlag("r")
+    err := tryParse(parser, []string{"-r", "foo", "-v"})
+    if err == nil || err.Error() != "no options allowed after --raw" {
+        t.Fail()
+    }
+}
+
+
+func TestTerminalFlagUnregistered(t *testing.T) {
+    var errBuf strings.Builder
+    parser := NewParser("", "")
+    parser.SetErr(&errBuf)
+    code := catchExit(func() {
+        parser.SetTerminalFlag("raw")
+    })
+    if code != 1 || !strings.Contains(errBuf.String(), "no option registered under the name 'raw'") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Capturing output.
+// -------------------------------------------------------------------------
+
+
+func TestCaptureError(t *testing.T) {
+    stdout, stderr, code := Capture(func(parser *ArgParser) {
+        parser.AddInt("num", 0)
+    }, []string{"--num", "foo"})
+    if stdout != "" || code != 1 || !strings.HasPrefix(stderr, "Error: ") {
+        t.Fatalf("got %q %q %v", stdout, stderr, code)
+    }
+}
+
+
+func TestCaptureHelp(t *testing.T) {
+    stdout, stderr, code := Capture(func(parser *ArgParser) {
+        parser.AddFlag("verbose").Desc("Print more output.")
+    }, []string{"--help"})
+    if !strings.Contains(stdout, "--verbose") || stderr != "" || code != 0 {
+        t.Fatalf("got %q %q %v", stdout, stderr, code)
+    }
+}
+
+
+func TestCaptureVersion(t *testing.T) {
+    stdout, _, code := Capture(func(parser *ArgParser) {
+        parser.SetVersionInfo("1.2.3", "", "")
+    }, []string{"--version"})
+    if !strings.HasPrefix(stdout, "1.2.3") || code != 0 {
+        t.Fatalf("got %q %v", stdout, code)
+    }
+}
+
+
+func TestCaptureSuccess(t *testing.T) {
+    var name string
+    stdout, stderr, code := Capture(func(parser *ArgParser) {
+        parser.StrVar(&name, "name", "")
+    }, []string{"--name", "foo"})
+    if stdout != "" || stderr != "" || code != 0 || name != "foo" {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Comma-separated lists.
+// -------------------------------------------------------------------------
+
+
+func TestCSVGreedyShortEquals(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddIntList("i", true)
+    parser.SetCSV("i")
+    parser.ParseArgs([]string{"-i=1,2,3"})
+    ints := parser.GetIntList("i")
+    if len(ints) != 3 || ints[0] != 1 || ints[1] != 2 || ints[2] != 3 {
+        t.Fatalf("got %v", ints)
+    }
+}
+
+
+func TestCSVNotSet(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStrList("s", true)
+    parser.ParseArgs([]string{"-s=a,b"})
+    if strs := parser.GetStrList("s"); len(strs) != 1 || strs[0] != "a,b" {
+        t.Fatalf("got %v", strs)
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Default accessors.
+// -------------------------------------------------------------------------
+
+
+func TestGetDefaults(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlagNegatable("flag", true)
+    parser.AddStr("str", "foo")
+    parser.AddInt("int", 1)
+    parser.AddFloat("float", 1.5)
+    parser.ParseArgs([]string{"--no-flag", "--str", "bar", "--int", "2", "--float", "2.5"})
+    if parser.GetFlag("flag") || !parser.GetFlagDefault("flag") {
+        t.Fail()
+    }
+    if parser.GetStr("str") != "bar" || parser.GetStrDefault("str") != "foo" {
+        t.Fail()
+    }
+    if parser.GetInt("int") != 2 || parser.GetIntDefault("int") != 1 {
+        t.Fail()
+    }
+    if parser.GetFloat("float") != 2.5 || parser.GetFloatDefault("float") != 1.5 {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Value templating.
+// -------------------------------------------------------------------------
+
+
+func TestValueTemplating(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnableValueTemplating()
+    parser.AddStr("name", "out")
+    parser.AddInt("num", 1)
+    parser.AddStr("outfile", "{name}.txt")
+    parser.AddStr("logfile", "")
+    parser.ParseArgs([]string{"--name", "foo", "--logfile", "{name}-{num}.log"})
+    if parser.GetStr("outfile") != "foo.txt" {
+        t.Fail()
+    }
+    if parser.GetStr("logfile") != "foo-1.log" {
+        t.Fail()
+    }
+}
+
+
+func TestValueTemplatingUnresolved(t *testing.T) {
+    parser := NewParser("", "")
+    parser.EnableValueTemplating()
+    parser.AddStr("outfile", "")
+    err := tryParse(parser, []string{"--outfile", "{nope}.txt"})
+    if err == nil || !strings.Contains(err.Error(), "'{nope}'") {
+        t.Fail()
+    }
+}
+
+
+func TestValueTemplatingOff(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("outfile", "{name}.txt")
+    parser.ParseArgs([]string{})
+    if parser.GetStr("outfile") != "{name}.txt" {
+        t.Fail()
+    }
+}
+
+
+func TestLoadJSONConfig(t *testing.T) {
+    path := writeArgsFile(t, `{"name": "foo", "num": 3, "verbose": true, "tags": ["a", "b"]}`)
+    parser := NewParser("", "")
+    parser.AddStr("name", "")
+    parser.AddInt("num", 0)
+    parser.AddFlag("verbose")
+    parser.AddStrList("tags", false)
+    if err := parser.LoadJSONConfig(path); err != nil {
+        t.Fatal(err)
+    }
+    parser.ParseArgs([]string{"--name", "bar"})
+    if parser.GetStr("name") != "bar" || parser.GetInt("num") != 3 {
+        t.Fail()
+    }
+    if !parser.GetFlag("verbose") || len(parser.GetStrList("tags")) != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestLoadJSONConfigBeforeParsing(t *testing.T) {
+    path := writeArgsFile(t, `{"name": "config", "num": 3}`)
+    var name string
+    parser := NewParser("", "")
+    parser.StrVar(&name, "name", "")
+    parser.AddIntEnv("num", 0, "CLIO_TEST_JSON_NUM")
+    parser.Require("name")
+    t.Setenv("CLIO_TEST_JSON_NUM", "4")
+    if err := parser.LoadJSONConfig(path); err != nil {
+        t.Fatal(err)
+    }
+    if err := tryParse(parser, []string{}); err != nil {
+        t.Fatal(err)
+    }
+    if name != "config" || parser.SourceOf("name") != SourceConfig {
+        t.Fail()
+    }
+    if parser.GetInt("num") != 4 || parser.SourceOf("num") != SourceEnv {
+        t.Fail()
+    }
+}
+
+
+func TestLoadJSONConfigTypeMismatch(t *testing.T) {
+    path := writeArgsFile(t, `{"num": "three"}`)
+    parser := NewParser("", "")
+    parser.AddInt("num", 0)
+    err := parser.LoadJSONConfig(path)
+    if err == nil || !strings.Contains(err.Error(), "'num'") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Duplicate registration.
+// -------------------------------------------------------------------------
+
+
+func TestDuplicateOptionPanics(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("name n", "")
+    defer func() {
+        r := recover()
+        if r == nil || !strings.Contains(fmt.Sprint(r), "option name 'n' is already registered") {
+            t.Fail()
+        }
+    }()
+    parser.AddStrList("names n", false)
+}
+
+
+func TestDuplicateOptionAllowOverride(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AllowOverride(true)
+    parser.AddStr("name", "foo")
+    parser.AddInt("name", 1)
+    parser.ParseArgs([]string{"--name", "2"})
+    if parser.GetInt("name") != 2 {
+        t.Fail()
+    }
+}
+
+
+func TestDuplicateOptionOverrideRemovesName(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AllowOverride(true)
+    parser.AddStr("name n", "foo")
+    parser.AddInt("number n", 1)
+    help := parser.BuildHelp()
+    if !strings.Contains(help, "  --name <str>") || !strings.Contains(help, "  -n, --number <int>") {
+        t.Fail()
+    }
+}
+
+
+func TestDuplicateOptionValueAlias(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddStr("log-level", "info")
+    parser.AddValueAlias("verbose", "log-level", "debug")
+    defer func() {
+        if recover() == nil {
+            t.Fail()
+        }
+    }()
+    parser.AddFlag("verbose")
+}
+
+
+// -------------------------------------------------------------------------
+// Command categories.
+// -------------------------------------------------------------------------
+
+
+func TestBuildHelpChoices(t *testing.T) {
+    parser := NewParser("Help!", "")
+    parser.AddStrChoices("format", "json", []string{"json", "yaml"}).Desc("output format")
+    expected := "  --format <str>  output format (choose from: json, yaml) (default: json)"
+    if !strings.Contains(parser.BuildHelp(), expected) {
+        t.Fail()
+    }
+}
+
+
+func TestBuildHelpCommandCategories(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddCmd("commit", "", callback)
+    parser.AddCmd("cat-file", "", callback)
+    parser.AddCmd("push", "", callback)
+    parser.AddCmd("misc", "", callback)
+    parser.SetCommandCategory("push", "Porcelain")
+    parser.SetCommandCategory("commit", "Porcelain")
+    parser.SetCommandCategory("cat-file", "Plumbing")
+    expected := strings.Join([]string{
+        "Usage: " + progName() + " [command]",
+        "",
+        "Porcelain:",
+        "  commit",
+        "  push",
+        "",
+        "Plumbing:",
+        "  cat-file",
+        "",
+        "Other Commands:",
+        "  misc",
+    }, "\n")
+    if help := parser.BuildHelp(); help != expected {
+        t.Fatalf("got:\n%v", help)
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Generic retrieval.
+// -------------------------------------------------------------------------
+
+
+func TestGetValue(t *testing.T) {
+    parser := NewParser("", "")
+    parser.AddFlag("flag")
+    parser.AddStr("str", "foo")
+    parser.AddInt("int", 0)
+    parser.AddFloat("float", 0)
+    parser.AddIntList("ints", true)
+    parser.ParseArgs([]string{"--flag", "--int", "1", "--float", "1.5", "--ints", "2", "3"})
+    if parser.GetValue("flag") != true || parser.GetValue("str") != "foo" {
+        t.Fail()
+    }
+    if parser.GetValue("int") != 1 || parser.GetValue("float") != 1.5 {
+        t.Fail()
+    }
+    if parser.GetValue("ints") != 3 {
+        t.Fail()
+    }
+    ints := parser.GetValueList("ints")
+    if len(ints) != 2 || ints[0] != 2 || ints[1] != 3 {
+        t.Fail()
+    }
+}
+
+
+func TestGetValueUnregistered(t *testing.T) {
+    var errBuf strings.Builder
+    parser := NewParser("", "")
+    parser.SetErr(&errBuf)
+    code := catchExit(func() {
+        parser.GetValue("nope")
+    })
+    if code != 1 || !strings.Contains(errBuf.String(), "no option registered under the name 'nope'") {
+        t.Fail()
+    }
+}
+
+
+// -------------------------------------------------------------------------
+// Unregistered names.
+// -------------------------------------------------------------------------
+
+
+func TestUnregisteredNameExits(t *testing.T) {
+    calls := map[string]func(*ArgParser){
+        "GetFlag": func(p *ArgParser) { p.GetFlag("nope") },
+        "GetStr": func(p *ArgParser) { p.GetStr("nope") },
+        "GetInt": func(p *ArgParser) { p.GetInt("nope") },
+        "GetFloat": func(p *ArgParser) { p.GetFloat("nope") },
+        "GetStrList": func(p *ArgParser) { p.GetStrList("nope") },
+        "Found": func(p *ArgParser) { p.Found("nope") },
+        "LenList": func(p *ArgParser) { p.LenList("nope") },
+        "SetStr": func(p *ArgParser) { p.SetStr("nope", "foo") },
+        "GetIntList": func(p *ArgParser) { p.GetIntList("nope") },
+        "SetRange": func(p *ArgParser) { p.SetRange("nope", 0, 1) },
+        "SetDelimiter": func(p *ArgParser) { p.SetDelimiter("nope", ",") },
+        "SetCSV": func(p *ArgParser) { p.SetCSV("nope") },
+        "SetListValidator": func(p *ArgParser) { p.SetListValidator("nope", nil) },
+        "SetEnv": func(p *ArgParser) { p.SetEnv("nope", "NOPE") },
+        "MarkSecret": func(p *ArgParser) { p.MarkSecret("nope") },
+        "Require": func(p *ArgParser) { p.Require("nope") },
+        "SourceOf": func(p *ArgParser) { p.SourceOf("nope") },
+    }
+    for method, call := range calls {
+        var errBuf strings.Builder
+        parser := NewParser("", "")
+        parser.AddStr("name", "")
+        parser.SetErr(&errBuf)
+        parser.ParseArgs([]string{})
+        code := catchExit(func() {
+            call(parser)
+        })
+        if code != 1 || errBuf.String() != "Error: no option registered under the name 'nope'.\n" {
+            t.Errorf("%v: got %v %q", method, code, errBuf.String())
+        }
+    }
+}
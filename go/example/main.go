@@ -3,6 +3,7 @@ package main
 
 import (
     "fmt"
+    "os"
     "github.com/dmulholland/clio/go/clio"
 )
 
@@ -62,7 +63,7 @@ func main() {
 
     // We can now retrieve our option and argument values from the parser
     // instance. Here we simply dump the parser to stdout.
-    fmt.Println(parser)
+    parser.WriteTo(os.Stdout)
 }
 
 
@@ -71,6 +72,7 @@ func main() {
 // to stdout.
 func callback(parser *clio.ArgParser) {
     fmt.Println("---------- callback ----------")
-    fmt.Println(parser)
-    fmt.Println("------------------------------\n")
+    parser.WriteTo(os.Stdout)
+    fmt.Println("------------------------------")
+    fmt.Println()
 }